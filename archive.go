@@ -1,8 +1,13 @@
 package rardecode
 
 import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
 	"errors"
 	"hash"
+	"io"
+	"time"
 )
 
 const (
@@ -13,6 +18,11 @@ const (
 	decode70Ver
 )
 
+const (
+	maxServiceDataSize = 0x100000 // maximum size of service block data read into memory
+	maxVarintLen       = 10       // maximum bytes in a RAR 5 variable length integer
+)
+
 var (
 	ErrCorruptBlockHeader    = errors.New("rardecode: corrupt block header")
 	ErrCorruptFileHeader     = errors.New("rardecode: corrupt file header")
@@ -21,56 +31,113 @@ var (
 	ErrDecoderOutOfData      = errors.New("rardecode: decoder expected more data than is in packed file")
 	ErrArchiveEncrypted      = errors.New("rardecode: archive encrypted, password required")
 	ErrArchivedFileEncrypted = errors.New("rardecode: archived files encrypted, password required")
+	ErrBadPassword           = errors.New("rardecode: incorrect password")
+	ErrUnknownRecord         = errors.New("rardecode: unknown file header record")
+	ErrVarintTruncated       = errors.New("rardecode: truncated variable length integer in header")
+	ErrVarintOverflow        = errors.New("rardecode: variable length integer in header overflows 64 bits")
+)
+
+var (
+	// stored values of zero for each time format, rejected by the Strict option
+	zeroTimes = []time.Time{time.Unix(0, 0), parseDosTime(0), winFiletime(0)}
 )
 
+// headerPasswordErr converts an error returned while reading the first encrypted
+// block header of a volume, before the password has been verified, to ErrBadPassword.
+// Decrypting a header with the wrong key produces garbage, which surfaces as a
+// bad crc, an invalid header or a header size that extends past the end of the file.
+func headerPasswordErr(err error) error {
+	switch err {
+	case ErrBadHeaderCRC, ErrCorruptBlockHeader, ErrVarintTruncated, ErrVarintOverflow, io.ErrUnexpectedEOF:
+		return ErrBadPassword
+	}
+	return err
+}
+
+// varintErr reports whether err is an error returned by readBuf.uvarint.
+func varintErr(err error) bool {
+	return err == ErrVarintTruncated || err == ErrVarintOverflow
+}
+
+// readBuf is used to parse little endian values from header data.
+// Reading past the end of the buffer returns ErrCorruptBlockHeader, or for
+// variable length integers ErrVarintTruncated, and leaves the buffer empty.
 type readBuf []byte
 
-func (b *readBuf) byte() byte {
-	v := (*b)[0]
-	*b = (*b)[1:]
-	return v
+// next returns the next n bytes of b, or ErrCorruptBlockHeader if there are
+// less than n bytes.
+func (b *readBuf) next(n int) ([]byte, error) {
+	if n < 0 || len(*b) < n {
+		*b = (*b)[len(*b):]
+		return nil, ErrCorruptBlockHeader
+	}
+	v := (*b)[:n:n]
+	*b = (*b)[n:]
+	return v, nil
 }
 
-func (b *readBuf) uint16() uint16 {
-	v := uint16((*b)[0]) | uint16((*b)[1])<<8
-	*b = (*b)[2:]
-	return v
+func (b *readBuf) byte() (byte, error) {
+	v, err := b.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
 }
 
-func (b *readBuf) uint32() uint32 {
-	v := uint32((*b)[0]) | uint32((*b)[1])<<8 | uint32((*b)[2])<<16 | uint32((*b)[3])<<24
-	*b = (*b)[4:]
-	return v
+func (b *readBuf) uint16() (uint16, error) {
+	v, err := b.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(v), nil
 }
 
-func (b *readBuf) uint64() uint64 {
-	v := uint64((*b)[0]) | uint64((*b)[1])<<8 | uint64((*b)[2])<<16 | uint64((*b)[3])<<24 |
-		uint64((*b)[4])<<32 | uint64((*b)[5])<<40 | uint64((*b)[6])<<48 | uint64((*b)[7])<<56
-	*b = (*b)[8:]
-	return v
+func (b *readBuf) uint32() (uint32, error) {
+	v, err := b.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(v), nil
 }
 
-func (b *readBuf) bytes(n int) []byte {
-	v := (*b)[:n]
-	*b = (*b)[n:]
-	return v
+func (b *readBuf) uint64() (uint64, error) {
+	v, err := b.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(v), nil
+}
+
+// bytes returns the next n bytes of b, where n is a size read from the header
+// that may not fit in an int.
+func (b *readBuf) bytes(n uint64) ([]byte, error) {
+	if uint64(len(*b)) < n {
+		*b = (*b)[len(*b):]
+		return nil, ErrCorruptBlockHeader
+	}
+	return b.next(int(n))
 }
 
-func (b *readBuf) uvarint() uint64 {
+// uvarint reads a RAR 5 variable length integer. Each byte holds 7 bits of the
+// value, least significant first, with the high bit set on all but the last
+// byte. ErrVarintTruncated is returned if the buffer ends before the last byte,
+// and ErrVarintOverflow if the value doesn't fit in 64 bits. The buffer is left
+// empty on error.
+func (b *readBuf) uvarint() (uint64, error) {
 	var x uint64
-	var s uint
 	for i, n := range *b {
+		if i == maxVarintLen-1 && n > 1 {
+			*b = (*b)[len(*b):]
+			return 0, ErrVarintOverflow
+		}
+		x |= uint64(n&0x7f) << (7 * i)
 		if n < 0x80 {
 			*b = (*b)[i+1:]
-			return x | uint64(n)<<s
+			return x, nil
 		}
-		x |= uint64(n&0x7f) << s
-		s += 7
-
 	}
-	// if we run out of bytes, just return 0
 	*b = (*b)[len(*b):]
-	return 0
+	return 0, ErrVarintTruncated
 }
 
 // sliceReader implements the readSlice and peek functions.
@@ -86,6 +153,27 @@ type sliceReader interface {
 	peek(n int) ([]byte, error)      // return the next n bytes withough advancing reader
 }
 
+// bufSliceReader is a sliceReader that reads from a byte slice.
+type bufSliceReader []byte
+
+func (b *bufSliceReader) peek(n int) ([]byte, error) {
+	if len(*b) == 0 {
+		return nil, io.EOF
+	} else if len(*b) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return (*b)[:n], nil
+}
+
+func (b *bufSliceReader) readSlice(n int) ([]byte, error) {
+	v, err := b.peek(n)
+	if err != nil {
+		return nil, err
+	}
+	*b = (*b)[n:]
+	return v[:n:n], nil
+}
+
 // fileBlockHeader represents a file block in a RAR archive.
 // Files may comprise one or more file blocks.
 // Solid files retain decode tables and dictionary from previous solid files in the archive.
@@ -99,31 +187,199 @@ type fileBlockHeader struct {
 	sum      []byte           // expected checksum for file contents
 	decVer   int              // decoder to use for file
 	key      []byte           // key for AES, non-empty if file encrypted
+	block    cipher.Block     // cached AES cipher for key, created from key if nil
 	iv       []byte           // iv for AES, non-empty if file encrypted
 	genKeys  func() error     // generates key & iv fields
+	pwCheck  bool             // genKeys checks the password without decrypting file data
+	stream   string           // NTFS stream name stored in a STM service block
+	rrSecs   int              // recovery sectors stored in a RAR 3.x RR service block
 	FileHeader
 }
 
+// hasNoData returns true if the file refers to another file for its contents.
+func (f *fileBlockHeader) hasNoData() bool {
+	return f.RedirectType == RedirectHardLink || f.RedirectType == RedirectFileCopy
+}
+
+// isExtendedAttr returns true if a service block with the given name contains
+// extended attributes or security data for the preceding file.
+func isExtendedAttr(name string) bool {
+	switch name {
+	case "ACL", "EA2", "EABE":
+		return true
+	}
+	return false
+}
+
+// isOptionalService reports whether a service block with the given name only
+// holds optional data, so the Permissive option can skip it if its header is
+// corrupt.
+func isOptionalService(name string) bool {
+	switch name {
+	case "CMT", "QO", "RR", "AV", "STM", "UOW", "ACL", "EA2", "EABE":
+		return true
+	}
+	return false
+}
+
+// skipBadBlock skips the data of a block with a bad header crc for the
+// Permissive option. It returns ErrBadHeaderCRC instead if the data size is
+// larger than the rest of the volume, as the size must then be corrupt too.
+func skipBadBlock(v *volume, size int64) error {
+	if n := v.remaining(); n >= 0 && size > n {
+		return ErrBadHeaderCRC
+	}
+	if err := v.discard(size); err != nil {
+		return err
+	}
+	v.addDamage(v.boff, ErrBadHeaderCRC)
+	return nil
+}
+
+// checkStrict returns an error if f contains an anomaly rejected by the
+// Strict option.
+func checkStrict(f *fileBlockHeader) error {
+	if f.Method == MethodStore && f.first && f.last && !f.IsDir && !f.Encrypted && !f.UnKnownSize &&
+		!f.hasNoData() && f.PackedSize != f.UnPackedSize {
+		return ErrCorruptFileHeader
+	}
+	for i, t := range []time.Time{f.ModificationTime, f.CreationTime, f.AccessTime} {
+		if f.StoredTimes&(TimeModification<<i) == 0 {
+			continue
+		}
+		for _, z := range zeroTimes {
+			if t.Equal(z) {
+				return ErrCorruptFileHeader
+			}
+		}
+	}
+	return nil
+}
+
+// readServiceData reads and decodes the data for service block f from v.
+func readServiceData(v *volume, f *fileBlockHeader) ([]byte, error) {
+	b, err := v.readSlice(int(f.PackedSize))
+	if err != nil {
+		return nil, err
+	}
+	if f.decVer == 0 {
+		b = append([]byte(nil), b...)
+	} else {
+		// data can't reference anything before the start of the block,
+		// so the window only needs to be as big as the unpacked data.
+		size := min(f.winSize, max(int(f.UnPackedSize), minWindowSize))
+		dr := new(decodeReader)
+		err = dr.init(newBufByteReader(b), f.decVer, size, true, f.UnPackedSize)
+		if err != nil {
+			return nil, err
+		}
+		b = make([]byte, f.UnPackedSize)
+		_, err = io.ReadFull(dr, b)
+		dr.release()
+		if err != nil {
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				err = ErrShortFile
+			}
+			return nil, err
+		}
+	}
+	if int64(len(b)) > f.UnPackedSize {
+		b = b[:f.UnPackedSize]
+	}
+	if f.hash != nil {
+		h := f.hash()
+		_, _ = h.Write(b)
+		if !bytes.Equal(h.Sum(nil), f.sum) {
+			return nil, ErrBadFileChecksum
+		}
+	}
+	return b, nil
+}
+
+// addServiceData reads the data for service block f and adds it to file if it
+// contains extended attributes. Otherwise the data is skipped.
+func addServiceData(v *volume, file *FileHeader, f *fileBlockHeader) error {
+	if v.opt.hdrOnly || file == nil || !isExtendedAttr(f.Name) || !f.first || !f.last || f.Encrypted ||
+		f.UnKnownSize || f.PackedSize > maxServiceDataSize || f.UnPackedSize > maxServiceDataSize {
+		return v.discard(f.PackedSize)
+	}
+	b, err := readServiceData(v, f)
+	if err == ErrBadFileChecksum && v.opt.lenient {
+		v.addDamage(v.off-f.PackedSize, err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if file.ExtendedAttrs == nil {
+		file.ExtendedAttrs = make(map[string][]byte)
+	}
+	file.ExtendedAttrs[f.Name] = b
+	return nil
+}
+
+// addStream adds the NTFS alternate data stream stored in service block f to
+// file. v must be at the start of the block data, and fbr the fileBlockReader
+// that read the block. The stream can only be opened later if it is stored in
+// a single block of a volume that can be opened again.
+func addStream(v *volume, fbr fileBlockReader, file *FileHeader, f *fileBlockHeader) {
+	if file == nil || f.Name != "STM" || !f.first {
+		return
+	}
+	s := StreamHeader{
+		Name:         f.stream,
+		PackedSize:   f.PackedSize,
+		UnPackedSize: f.UnPackedSize,
+		Encrypted:    f.Encrypted,
+	}
+	if f.last && (v.ra != nil || len(v.file) > 0) {
+		s.pr = &packedFileReader{v: v.clone(), r: fbr.clone(), h: f, n: f.PackedSize}
+	}
+	file.Streams = append(file.Streams, s)
+}
+
 // fileBlockReader returns the next fileBlockHeader in a volume.
 type fileBlockReader interface {
 	next(v *volume) (*fileBlockHeader, error) // reads the volume and returns the next fileBlockHeader
 	clone() fileBlockReader                   // makes a copy of the fileBlockReader
 }
 
-func newFileBlockReader(v *volume) (fileBlockReader, error) {
-	pass := v.opt.pass
-	if pass != nil {
-		runes := []rune(*pass)
-		if len(runes) > maxPassword {
-			pw := string(runes[:maxPassword])
-			pass = &pw
-		}
+// nameDecoder converts a file name stored in a legacy codepage to a string.
+type nameDecoder func(name []byte) (string, error)
+
+// nameFunc transforms a file name, see NormalizeNames.
+type nameFunc func(name string) string
+
+// passwordFunc is called to request a password for an encrypted file or archive.
+type passwordFunc func(fh *FileHeader, attempt int) (string, error)
+
+// truncPassword truncates pass to the maximum password length.
+func truncPassword(pass string) string {
+	runes := []rune(pass)
+	if len(runes) > maxPassword {
+		return string(runes[:maxPassword])
 	}
+	return pass
+}
+
+func newFileBlockReader(v *volume) (fileBlockReader, error) {
 	switch v.ver {
-	case 0:
-		return newArchive15(pass), nil
-	case 1:
-		return newArchive50(pass), nil
+	case FormatRAR15:
+		a := newArchive15(v.opt.pass, v.opt.passFn)
+		a.passes = v.opt.passes
+		a.maxHdr = v.opt.maxHdr
+		a.nameDec = v.opt.nameDec
+		a.strict = v.opt.check
+		a.lenient = v.opt.lenient
+		a.dec = v.opt.dec
+		return a, nil
+	case FormatRAR50:
+		a := newArchive50(v.opt.pass, v.opt.passFn)
+		a.passes = v.opt.passes
+		a.maxHdr = v.opt.maxHdr
+		a.strict = v.opt.check
+		a.lenient = v.opt.lenient
+		a.dec = v.opt.dec
+		return a, nil
 	default:
 		return nil, ErrUnknownVersion
 	}
@@ -6,9 +6,10 @@ import (
 	"errors"
 	"hash/crc32"
 	"io"
-	"os"
+	"io/fs"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf16"
 )
@@ -18,6 +19,10 @@ const (
 	blockArc     = 0x73
 	blockFile    = 0x74
 	blockComment = 0x75
+	blockOldAuth = 0x76 // authenticity verification, RAR 2.0 and earlier
+	blockOldSub  = 0x77 // subblock, RAR 2.x
+	blockOldRR   = 0x78 // recovery record, RAR 2.x
+	blockAuth    = 0x79 // authenticity verification
 	blockService = 0x7a
 	blockEnd     = 0x7b
 
@@ -27,9 +32,12 @@ const (
 	// archive block flags
 	arcVolume    = 0x0001
 	arcComment   = 0x0002
+	arcLocked    = 0x0004
 	arcSolid     = 0x0008
 	arcNewNaming = 0x0010
+	arcRecovery  = 0x0040
 	arcEncrypted = 0x0080
+	arcFirstVol  = 0x0100
 
 	// file block flags
 	fileSplitBefore = 0x0001
@@ -44,10 +52,12 @@ const (
 	fileExtTime     = 0x1000
 
 	// end block flags
-	endArcNotLast = 0x0001
+	endArcNotLast   = 0x0001
+	endArcDataCRC   = 0x0002
+	endArcVolNumber = 0x0008
 
-	saltSize    = 8 // size of salt for calculating AES keys
-	cacheSize30 = 4 // number of AES keys to cache
+	saltSize    = 8   // size of salt for calculating AES keys
+	cacheSize30 = 256 // maximum number of AES keys to cache
 	hashRounds  = 0x40000
 )
 
@@ -60,24 +70,34 @@ type blockHeader15 struct {
 	flags    uint16
 	data     readBuf // header data
 	dataSize int64   // size of extra block data
+	raw      []byte  // header as stored, after decryption
+	badCRC   bool    // header crc is incorrect, only allowed for non-critical blocks
 }
 
 // archive15 implements fileBlockReader for RAR 1.5 file format archives
 type archive15 struct {
 	multi     bool // archive is multi-volume
 	solid     bool // archive is a solid archive
+	strict    bool // return ErrUnexpectedArcEnd if there is no end block
+	lenient   bool // allow bad header crcs for blocks other than archive, file and end blocks
 	encrypted bool
-	pass      []uint16              // password in UTF-16
-	keyCache  [cacheSize30]struct { // cache of previously calculated decryption keys
-		salt []byte
-		key  []byte
-		iv   []byte
-	}
+	verified  bool                 // encrypted block headers have been successfully decrypted
+	maxHdr    int                  // maximum block header size (0 for no limit)
+	nameDec   nameDecoder          // optional decoder for names that aren't unicode
+	file      *FileHeader          // header of last file, used to store service data
+	pass      []uint16             // password in UTF-16
+	passFn    passwordFunc         // optional function to request a password
+	passes    []string             // passwords to try in order before calling passFn
+	passIdx   int                  // index in passes of pass, or -1
+	mu        *sync.Mutex          // protects password and keys, as keys may be generated lazily
+	keyCache  map[string][2][]byte // key and iv by password and salt, shared by clones
+	dec       Decrypter            // creates decrypters, nil to use crypto/aes
 }
 
 func (a *archive15) clone() fileBlockReader {
 	na := new(archive15)
 	*na = *a
+	na.file = nil
 	return na
 }
 
@@ -124,6 +144,17 @@ func parseDosTime(t uint32) time.Time {
 	return time.Date(yr, mon, day, hr, min, sec, 0, time.Local)
 }
 
+// decodeLegacyName converts a file name stored without unicode using the
+// NameDecoder option, if set.
+func (a *archive15) decodeLegacyName(name []byte) string {
+	if a.nameDec != nil {
+		if s, err := a.nameDec(name); err == nil {
+			return s
+		}
+	}
+	return string(name)
+}
+
 // decodeName decodes a non-unicode filename from a file header.
 func decodeName(buf []byte) string {
 	i := bytes.IndexByte(buf, 0)
@@ -133,16 +164,21 @@ func decodeName(buf []byte) string {
 
 	name := buf[:i]
 	encName := readBuf(buf[i+1:])
-	if len(encName) < 2 {
+	hb, err := encName.byte()
+	if err != nil {
+		return "" // invalid encoding
+	}
+	highByte := uint16(hb) << 8
+	flags, err := encName.byte()
+	if err != nil {
 		return "" // invalid encoding
 	}
-	highByte := uint16(encName.byte()) << 8
-	flags := encName.byte()
 	flagBits := 8
 	var wchars []uint16 // decoded characters are UTF-16
+	// a truncated encoding stops decoding, keeping the characters decoded so far
 	for len(wchars) < len(name) && len(encName) > 0 {
 		if flagBits == 0 {
-			flags = encName.byte()
+			flags, _ = encName.byte()
 			flagBits = 8
 			if len(encName) == 0 {
 				break
@@ -150,25 +186,28 @@ func decodeName(buf []byte) string {
 		}
 		switch flags >> 6 {
 		case 0:
-			wchars = append(wchars, uint16(encName.byte()))
+			c, _ := encName.byte()
+			wchars = append(wchars, uint16(c))
 		case 1:
-			wchars = append(wchars, uint16(encName.byte())|highByte)
+			c, _ := encName.byte()
+			wchars = append(wchars, uint16(c)|highByte)
 		case 2:
-			if len(encName) < 2 {
+			c, err := encName.uint16()
+			if err != nil {
 				break
 			}
-			wchars = append(wchars, encName.uint16())
+			wchars = append(wchars, c)
 		case 3:
-			n := encName.byte()
+			n, _ := encName.byte()
 			b := name[len(wchars):]
 			if l := int(n&0x7f) + 2; l < len(b) {
 				b = b[:l]
 			}
 			if n&0x80 > 0 {
-				if len(encName) < 1 {
+				ec, err := encName.byte()
+				if err != nil {
 					break
 				}
-				ec := encName.byte()
 				for _, c := range b {
 					wchars = append(wchars, uint16(c+ec)|highByte)
 				}
@@ -185,11 +224,12 @@ func decodeName(buf []byte) string {
 }
 
 // readExtTimes reads and parses the optional extra time field from the file header.
+// Times are only set from complete fields, so truncated data is ignored.
 func readExtTimes(f *fileBlockHeader, b *readBuf) {
-	if len(*b) < 2 {
+	flags, err := b.uint16()
+	if err != nil {
 		return // invalid, not enough data
 	}
-	flags := b.uint16()
 
 	ts := []*time.Time{&f.ModificationTime, &f.CreationTime, &f.AccessTime}
 
@@ -199,10 +239,12 @@ func readExtTimes(f *fileBlockHeader, b *readBuf) {
 			continue
 		}
 		if i != 0 { // ModificationTime already read so skip
-			if len(*b) < 4 {
+			dt, err := b.uint32()
+			if err != nil {
 				return // invalid, not enough data
 			}
-			*t = parseDosTime(b.uint32())
+			*t = parseDosTime(dt)
+			f.StoredTimes |= TimeModification << i
 		}
 		if n&0x4 > 0 {
 			*t = t.Add(time.Second)
@@ -211,42 +253,93 @@ func readExtTimes(f *fileBlockHeader, b *readBuf) {
 		if n == 0 {
 			continue
 		}
-		if len(*b) < int(n) {
+		frac, err := b.bytes(uint64(n))
+		if err != nil {
 			return // invalid, not enough data
 		}
 		// add extra time data in 100's of nanoseconds
 		d := time.Duration(0)
-		for j := 3 - n; j < n; j++ {
-			d |= time.Duration(b.byte()) << (j * 8)
+		for j, c := range frac {
+			d |= time.Duration(c) << ((3 - int(n) + j) * 8)
 		}
 		d *= 100
 		*t = t.Add(d)
 	}
 }
 
-func (a *archive15) getKeys(salt []byte) (key, iv []byte) {
-	// check cache of keys
-	for _, v := range a.keyCache {
-		if bytes.Equal(v.salt[:], salt) {
-			return v.key, v.iv
+// setPassword sets the password. Cached keys are kept as they are stored by password.
+func (a *archive15) setPassword(pass string) {
+	a.pass = utf16.Encode([]rune(truncPassword(pass))) // convert to UTF-16
+}
+
+// requirePassword checks a password has been set, using the first password from
+// passes or requesting one with the password function if available. noPassErr is
+// returned if there is no password. RAR 1.5 archives have no password check, so
+// only one password is tried.
+func (a *archive15) requirePassword(fh *FileHeader, noPassErr error) error {
+	if a.pass == nil {
+		switch {
+		case len(a.passes) > 0:
+			a.setPassword(a.passes[0])
+			a.passIdx = 0
+		case a.passFn != nil:
+			pass, err := a.passFn(fh, 0)
+			if err != nil {
+				return err
+			}
+			a.setPassword(pass)
+		default:
+			return noPassErr
 		}
 	}
-	key, iv = calcAes30Params(a.pass, salt)
+	if fh != nil {
+		fh.PasswordIndex = a.passIdx
+	}
+	return nil
+}
 
-	// save a copy in the cache
-	copy(a.keyCache[1:], a.keyCache[:])
-	a.keyCache[0].salt = append([]byte(nil), salt...) // copy so byte slice can be reused
-	a.keyCache[0].key = key
-	a.keyCache[0].iv = iv
+// getKeys returns the AES key and iv for the current password and salt.
+// Keys are cached as calculating them is slow, and archives with many encrypted
+// files often use the same salt for each one.
+func (a *archive15) getKeys(salt []byte) (key, iv []byte) {
+	id := make([]byte, 0, len(salt)+len(a.pass)*2)
+	id = append(id, salt...)
+	for _, c := range a.pass {
+		id = append(id, byte(c), byte(c>>8))
+	}
+	if k, ok := a.keyCache[string(id)]; ok {
+		return k[0], k[1]
+	}
+	key, iv = calcAes30Params(a.pass, salt)
 
+	if len(a.keyCache) >= cacheSize30 {
+		clear(a.keyCache)
+	}
+	a.keyCache[string(id)] = [2][]byte{key, iv}
 	return key, iv
 }
 
+// parseUnixOwner parses the owner and group names stored in a "UOW" service
+// block header. The names are separated by a zero byte.
+func parseUnixOwner(f *fileBlockHeader, b []byte) {
+	owner, group, ok := bytes.Cut(b, []byte{0})
+	if !ok {
+		return // invalid, no separator
+	}
+	group, _, _ = bytes.Cut(group, []byte{0})
+	f.Owner = string(owner)
+	f.Group = string(group)
+}
+
 func (a *archive15) parseFileHeader(h *blockHeader15) (*fileBlockHeader, error) {
 	f := new(fileBlockHeader)
+	f.UID, f.GID = -1, -1
+	f.PasswordIndex = -1
 
+	f.raw = FileSys{Flags: uint64(h.flags), Format: FormatRAR15}
 	f.first = h.flags&fileSplitBefore == 0
 	f.last = h.flags&fileSplitAfter == 0
+	f.SplitBefore, f.SplitAfter = !f.first, !f.last
 
 	f.Solid = h.flags&fileSolid > 0
 	f.arcSolid = a.solid
@@ -257,41 +350,77 @@ func (a *archive15) parseFileHeader(h *blockHeader15) (*fileBlockHeader, error)
 		f.winSize = 0x10000 << ((h.flags & fileWindowMask) >> 5)
 	}
 
+	// a header truncated before the end of a field is a corrupt file header
 	b := h.data
-	if len(b) < 21 {
+	size, err := b.uint32()
+	if err != nil {
 		return nil, ErrCorruptFileHeader
 	}
-
 	f.PackedSize = h.dataSize
-	f.UnPackedSize = int64(b.uint32())
-	f.HostOS = b.byte() + 1
+	f.UnPackedSize = int64(size)
+	hostOS, err := b.byte()
+	if err != nil {
+		return nil, ErrCorruptFileHeader
+	}
+	f.HostOS = hostOS + 1
 	if f.HostOS > HostOSBeOS {
 		f.HostOS = HostOSUnknown
 	}
-	f.sum = append([]byte(nil), b.bytes(4)...)
+	sum, err := b.bytes(4)
+	if err != nil {
+		return nil, ErrCorruptFileHeader
+	}
+	f.sum = append([]byte(nil), sum...)
+	if f.last {
+		f.ChecksumType, f.Checksum = ChecksumCRC32, append([]byte(nil), f.sum...)
+	}
 
-	f.ModificationTime = parseDosTime(b.uint32())
-	unpackver := b.byte()     // decoder version
-	method := b.byte() - 0x30 // decryption method
-	namesize := int(b.uint16())
-	f.Attributes = int64(b.uint32())
+	mtime, err := b.uint32()
+	if err != nil {
+		return nil, ErrCorruptFileHeader
+	}
+	f.ModificationTime = parseDosTime(mtime)
+	f.StoredTimes = TimeModification
+	unpackver, err := b.byte() // decoder version
+	if err != nil {
+		return nil, ErrCorruptFileHeader
+	}
+	method, err := b.byte() // decryption method
+	if err != nil {
+		return nil, ErrCorruptFileHeader
+	}
+	method -= 0x30
+	namesize, err := b.uint16()
+	if err != nil {
+		return nil, ErrCorruptFileHeader
+	}
+	attr, err := b.uint32()
+	if err != nil {
+		return nil, ErrCorruptFileHeader
+	}
+	f.Attributes = int64(attr)
+	f.raw.ExtractVersion = int(unpackver)
 	if h.flags&fileLargeData > 0 {
-		if len(b) < 8 {
+		// large PackedSize was already read in readBlockHeader
+		if _, err = b.uint32(); err != nil {
+			return nil, ErrCorruptFileHeader
+		}
+		high, err := b.uint32()
+		if err != nil {
 			return nil, ErrCorruptFileHeader
 		}
-		_ = b.uint32() // already read large PackedSize in readBlockHeader
-		f.UnPackedSize |= int64(b.uint32()) << 32
+		f.UnPackedSize |= int64(high) << 32
 		f.UnKnownSize = f.UnPackedSize == -1
 	} else if int32(f.UnPackedSize) == -1 {
 		f.UnKnownSize = true
 		f.UnPackedSize = -1
 	}
-	if len(b) < namesize {
+	name, err := b.bytes(uint64(namesize))
+	if err != nil {
 		return nil, ErrCorruptFileHeader
 	}
-	name := b.bytes(namesize)
 	if h.flags&fileUnicode == 0 {
-		f.Name = string(name)
+		f.Name = a.decodeLegacyName(name)
 	} else {
 		f.Name = decodeName(name)
 	}
@@ -310,12 +439,37 @@ func (a *archive15) parseFileHeader(h *blockHeader15) (*fileBlockHeader, error)
 		}
 	}
 
+	if h.htype == blockService {
+		// service blocks store any extra data in the header
+		// between the name and salt, instead of extended times.
+		n := len(b)
+		if h.flags&fileSalt > 0 {
+			n -= saltSize
+		}
+		if n > 0 {
+			data, _ := b.bytes(uint64(n)) // n is at most len(b)
+			switch f.Name {
+			case "RR":
+				f.rrSecs = parseRRData(data)
+			case "UOW":
+				parseUnixOwner(f, data)
+			case "STM":
+				// UTF-16LE stream name
+				name := make([]uint16, len(data)/2)
+				for i, r := 0, readBuf(data); i < len(name); i++ {
+					name[i], _ = r.uint16() // data holds len(name) uint16s
+				}
+				f.stream = string(utf16.Decode(name))
+			}
+		}
+	}
 	var salt []byte
 	if h.flags&fileSalt > 0 {
-		if len(b) < saltSize {
+		s, err := b.bytes(saltSize)
+		if err != nil {
 			return nil, ErrCorruptFileHeader
 		}
-		salt = append([]byte(nil), b.bytes(saltSize)...)
+		salt = append([]byte(nil), s...)
 	}
 	if h.flags&fileExtTime > 0 {
 		readExtTimes(f, &b)
@@ -327,15 +481,20 @@ func (a *archive15) parseFileHeader(h *blockHeader15) (*fileBlockHeader, error)
 	// fields only needed for first block in a file
 	if h.flags&fileEncrypted > 0 && len(salt) == saltSize {
 		f.genKeys = func() error {
-			if a.pass == nil {
-				return ErrArchivedFileEncrypted
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			if err := a.requirePassword(&f.FileHeader, ErrArchivedFileEncrypted); err != nil {
+				return err
 			}
 			f.key, f.iv = a.getKeys(salt)
 			return nil
 		}
 	}
 	f.hash = newLittleEndianCRC32
+	f.Method = int(method)
 	if method != 0 {
+		f.CompressionVersion = int(unpackver)
+		f.DictionarySize = int64(f.winSize)
 		switch unpackver {
 		case 15:
 			return nil, ErrUnsupportedDecoder
@@ -354,15 +513,22 @@ func (a *archive15) parseFileHeader(h *blockHeader15) (*fileBlockHeader, error)
 // It will return io.EOF if there were no bytes read.
 func (a *archive15) readBlockHeader(r sliceReader) (*blockHeader15, error) {
 	if a.encrypted {
-		if a.pass == nil {
-			return nil, ErrArchiveEncrypted
+		a.mu.Lock()
+		err := a.requirePassword(nil, ErrArchiveEncrypted)
+		a.mu.Unlock()
+		if err != nil {
+			return nil, err
 		}
 		salt, err := r.readSlice(saltSize)
 		if err != nil {
 			return nil, err
 		}
+		a.mu.Lock()
 		key, iv := a.getKeys(salt)
-		r = newAesSliceReader(r, key, iv)
+		a.mu.Unlock()
+		if r, err = newAesSliceReader(r, a.dec, key, iv, nil); err != nil {
+			return nil, err
+		}
 	}
 	var b readBuf
 	var err error
@@ -374,11 +540,22 @@ func (a *archive15) readBlockHeader(r sliceReader) (*blockHeader15, error) {
 		}
 		return nil, err
 	}
-	crc := b.uint16()
+	crc, err := b.uint16()
+	if err != nil {
+		return nil, err
+	}
 	h := new(blockHeader15)
-	h.htype = b.byte()
-	h.flags = b.uint16()
-	size := int(b.uint16())
+	if h.htype, err = b.byte(); err != nil {
+		return nil, err
+	}
+	if h.flags, err = b.uint16(); err != nil {
+		return nil, err
+	}
+	hsize, err := b.uint16()
+	if err != nil {
+		return nil, err
+	}
+	size := int(hsize)
 	if h.htype == blockArc && h.flags&arcComment > 0 {
 		// comment block embedded into archive block
 		if size < 13 {
@@ -388,6 +565,9 @@ func (a *archive15) readBlockHeader(r sliceReader) (*blockHeader15, error) {
 	} else if size < 7 {
 		return nil, ErrCorruptBlockHeader
 	}
+	if a.maxHdr > 0 && size > a.maxHdr {
+		return nil, ErrLimitsExceeded
+	}
 	h.data, err = r.readSlice(size)
 	if err != nil {
 		if err == io.EOF {
@@ -405,31 +585,123 @@ func (a *archive15) readBlockHeader(r sliceReader) (*blockHeader15, error) {
 		_, _ = hash.Write(h.data[2:])
 	}
 	if crc != uint16(hash.Sum32()) {
-		return nil, ErrBadHeaderCRC
+		switch h.htype {
+		case blockComment, blockOldAuth, blockOldSub, blockOldRR, blockAuth, blockService:
+			// blocks that only hold optional data
+		default:
+			return nil, ErrBadHeaderCRC
+		}
+		if !a.lenient || (a.encrypted && !a.verified) {
+			return nil, ErrBadHeaderCRC
+		}
+		h.badCRC = true
 	}
+	h.raw = h.data
 	h.data = h.data[7:]
 	if h.flags&blockHasData > 0 {
-		if len(h.data) < 4 {
-			return nil, ErrCorruptBlockHeader
+		size, err := h.data.uint32()
+		if err != nil {
+			return nil, err
 		}
-		h.dataSize = int64(h.data.uint32())
+		h.dataSize = int64(size)
 	}
 	if (h.htype == blockService || h.htype == blockFile) && h.flags&fileLargeData > 0 {
-		if len(h.data) < 25 {
+		if len(h.data) < 21 {
+			return nil, ErrCorruptBlockHeader
+		}
+		b := h.data[21:]
+		high, err := b.uint32()
+		if err != nil {
+			return nil, err
+		}
+		h.dataSize |= int64(high) << 32
+		if h.dataSize < 0 {
 			return nil, ErrCorruptBlockHeader
 		}
-		b := h.data[21:25]
-		h.dataSize |= int64(b.uint32()) << 32
 	}
 	return h, nil
 }
 
+// blockTypeName15 returns the name of block type t, used to describe error locations.
+func blockTypeName15(t byte) string {
+	switch t {
+	case blockArc:
+		return "archive"
+	case blockFile:
+		return "file"
+	case blockComment:
+		return "comment"
+	case blockOldAuth, blockAuth:
+		return "authenticity"
+	case blockOldSub:
+		return "subblock"
+	case blockOldRR:
+		return "recovery record"
+	case blockService:
+		return "service"
+	case blockEnd:
+		return "end"
+	}
+	return "unknown"
+}
+
+// volumeNumber reads the block headers at the start of v, returning its volume
+// number, or -1 if it isn't a volume of a multi-volume archive. Old archives
+// only store the volume number in the end block, which will be read if needed.
+func (a *archive15) volumeNumber(v *volume) (int, error) {
+	for {
+		h, err := a.readBlockHeader(v)
+		if err != nil {
+			return -1, err
+		}
+		switch h.htype {
+		case blockArc:
+			if h.flags&arcVolume == 0 {
+				return -1, nil
+			}
+			if h.flags&arcFirstVol > 0 {
+				return 0, nil
+			}
+			a.encrypted = h.flags&arcEncrypted > 0
+		case blockEnd:
+			b := h.data
+			if h.flags&endArcVolNumber == 0 {
+				return -1, nil
+			}
+			if h.flags&endArcDataCRC > 0 {
+				_, _ = b.uint32() // ignore archive data crc
+			}
+			n, err := b.uint16()
+			if err != nil {
+				return -1, err
+			}
+			return int(n), nil
+		default:
+			if err = v.discard(h.dataSize); err != nil {
+				return -1, err
+			}
+		}
+	}
+}
+
+// validHeader reports whether r starts with a valid unencrypted block header.
+func (a *archive15) validHeader(r sliceReader) bool {
+	h, err := a.readBlockHeader(r)
+	return err == nil && h.htype >= blockArc && h.htype <= blockEnd
+}
+
 // next advances to the next file block in the archive
 func (a *archive15) next(v *volume) (*fileBlockHeader, error) {
 	for {
 		// could return an io.EOF here as 1.5 archives may not have an end block.
+		v.setBlock(v.off, "")
 		h, err := a.readBlockHeader(v)
 		if err != nil {
+			if a.encrypted && !a.verified {
+				err = headerPasswordErr(err)
+			} else if !a.encrypted && v.skipDamaged(err, a.validHeader) {
+				continue
+			}
 			// if reached end of file without an end block try to open next volume
 			if err == io.EOF {
 				a.encrypted = false // reset encryption when opening new volume file
@@ -438,28 +710,104 @@ func (a *archive15) next(v *volume) (*fileBlockHeader, error) {
 					continue
 				}
 				// new volume doesnt exist, assume end of archive
-				if os.IsNotExist(err) {
+				if errors.Is(err, fs.ErrNotExist) {
+					if a.strict {
+						return nil, ErrUnexpectedArcEnd
+					}
 					return nil, io.EOF
 				}
 			}
 			return nil, err
 		}
+		a.verified = a.encrypted
+		v.blk = blockTypeName15(h.htype)
+		if err = v.reportBlock(int(h.htype), h.raw, h.dataSize); err != nil {
+			return nil, err
+		}
+		if h.badCRC {
+			if h.htype == blockService {
+				if f, err := a.parseFileHeader(h); err != nil || !isOptionalService(f.Name) {
+					return nil, ErrBadHeaderCRC
+				}
+			}
+			if err = skipBadBlock(v, h.dataSize); err != nil {
+				return nil, err
+			}
+			continue
+		}
 		switch h.htype {
 		case blockFile:
-			return a.parseFileHeader(h)
+			f, err := a.parseFileHeader(h)
+			if err == ErrCorruptFileHeader && v.opt.skipDmg {
+				if err = v.discard(h.dataSize); err != nil {
+					return nil, err
+				}
+				v.addDamage(v.boff, ErrCorruptFileHeader)
+				continue
+			}
+			if err == nil && f.first {
+				a.file = &f.FileHeader
+			}
+			return f, err
+		case blockService:
+			f, perr := a.parseFileHeader(h)
+			if perr != nil {
+				err = v.discard(h.dataSize) // can't parse, skip over block data
+			} else {
+				if f.Name == "UOW" && a.file != nil {
+					a.file.Owner, a.file.Group = f.Owner, f.Group
+				} else if f.Name == "RR" {
+					v.addRecovery(f.PackedSize, f.rrSecs, 0)
+				}
+				addStream(v, a, a.file, f)
+				err = addServiceData(v, a.file, f)
+			}
 		case blockArc:
 			a.encrypted = h.flags&arcEncrypted > 0
 			a.multi = h.flags&arcVolume > 0
 			if v.num == 0 {
 				v.old = h.flags&arcNewNaming == 0
+				// The volume number is only stored in the end block, so
+				// v.num is updated when it is read, unless it is already
+				// known from the position reading was resumed at.
+				v.mid = a.multi && h.flags&arcFirstVol == 0
+				if v.mid {
+					v.num = v.snum
+				}
 			}
 			a.solid = h.flags&arcSolid > 0
+			v.arc = archiveFlags{
+				solid:    a.solid,
+				locked:   h.flags&arcLocked > 0,
+				recovery: h.flags&arcRecovery > 0,
+				volume:   a.multi,
+				first:    a.multi && h.flags&arcFirstVol > 0,
+			}
 		case blockEnd:
+			if h.flags&endArcVolNumber > 0 {
+				b := h.data
+				if h.flags&endArcDataCRC > 0 {
+					_, _ = b.uint32() // ignore archive data crc
+				}
+				n, err := b.uint16()
+				switch {
+				case err != nil:
+					// volume number missing, ignore it
+				case v.mid && v.num == 0:
+					v.num = int(n) // started reading from a later volume
+				case int(n) != v.num:
+					return nil, ErrBadVolumeNumber
+				}
+			}
 			if h.flags&endArcNotLast == 0 || !a.multi {
-				return nil, io.EOF
+				return nil, v.archiveEnd()
 			}
 			a.encrypted = false // reset encryption when opening new volume file
 			err = v.next()
+		case blockOldRR:
+			sectors, blocks := parseProtectHeader(h.data)
+			v.addRecovery(h.dataSize, sectors, blocks)
+			err = v.discard(h.dataSize)
 		default:
 			if h.dataSize > 0 {
 				err = v.discard(h.dataSize) // skip over block data
@@ -472,10 +820,10 @@ func (a *archive15) next(v *volume) (*fileBlockHeader, error) {
 }
 
 // newArchive15 creates a new fileBlockReader for a Version 1.5 archive
-func newArchive15(password *string) *archive15 {
-	a := new(archive15)
+func newArchive15(password *string, passFn passwordFunc) *archive15 {
+	a := &archive15{passFn: passFn, passIdx: -1, mu: new(sync.Mutex), keyCache: make(map[string][2][]byte)}
 	if password != nil {
-		a.pass = utf16.Encode([]rune(*password)) // convert to UTF-16
+		a.setPassword(*password)
 	}
 	return a
 }
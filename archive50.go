@@ -2,22 +2,27 @@ package rardecode
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
 	"hash"
 	"hash/crc32"
 	"io"
+	"io/fs"
 	"math"
 	"math/bits"
+	"sync"
 	"time"
 )
 
 const (
 	// block types
-	block5Arc  = 1
-	block5File = 2
-	// block5Service = 3
+	block5Arc     = 1
+	block5File    = 2
+	block5Service = 3
 	block5Encrypt = 4
 	block5End     = 5
 
@@ -34,8 +39,18 @@ const (
 	enc5CheckPresent = 0x0001 // password check data is present
 
 	// main archive block flags
-	arc5MultiVol = 0x0001
-	arc5Solid    = 0x0004
+	arc5MultiVol  = 0x0001
+	arc5VolNumber = 0x0002
+	arc5Solid     = 0x0004
+	arc5Recovery  = 0x0008
+	arc5Locked    = 0x0010
+
+	// main archive block extra record types
+	arc5ExtraLocator  = 1
+	arc5ExtraMetadata = 2
+
+	// locator record flags
+	locator5QuickOpen = 0x0001 // quick open offset present
 
 	// file block flags
 	file5IsDir          = 0x0001
@@ -62,16 +77,32 @@ const (
 	file5ExtraTimeHasATime   = 0x08 // has access time
 	file5ExtraTimeHasUnixNS  = 0x10 // unix nanosecond time format
 
-	cacheSize50   = 4
+	// file hash record types
+	file5HashBlake2 = 0 // BLAKE2sp
+	hashSizeBlake2  = 32
+
+	// file redirection record types
+	file5RedirMax = 5 // highest known redirection type
+
+	// unix owner record flags
+	file5OwnerHasName  = 0x01 // user name present
+	file5OwnerHasGroup = 0x02 // group name present
+	file5OwnerHasUID   = 0x04 // numeric user id present
+	file5OwnerHasGID   = 0x08 // numeric group id present
+
+	cacheSize50   = 256 // maximum number of keys to cache
 	maxPbkdf2Salt = 64
 	pwCheckSize   = 8
 	maxKdfCount   = 24
 
 	maxDictSize = 0x1000000000 // maximum dictionary size 64GB
+
+	maxHeaderSize50 = 0x200000 // maximum block header size
+
+	maxQuickOpenSize = 0x4000000 // maximum size of quick open data read into memory
 )
 
 var (
-	ErrBadPassword          = errors.New("rardecode: incorrect password")
 	ErrCorruptEncryptData   = errors.New("rardecode: corrupt encryption data")
 	ErrUnknownEncryptMethod = errors.New("rardecode: unknown encryption method")
 	ErrPlatformIntSize      = errors.New("rardecode: platform integer size too small")
@@ -89,6 +120,8 @@ type blockHeader50 struct {
 	data     readBuf // block header data
 	extra    []extra // extra fields
 	dataSize int64   // size of block data
+	raw      []byte  // header as stored, after decryption
+	badCRC   bool    // header crc is incorrect, only allowed for service blocks
 }
 
 // leHash32 wraps a hash.Hash32 to return the result of Sum in little
@@ -109,19 +142,33 @@ func newLittleEndianCRC32() hash.Hash {
 // archive50 implements fileBlockReader for RAR 5 file format archives
 type archive50 struct {
 	pass     []byte
-	blockKey []byte                // key used to encrypt blocks
-	multi    bool                  // archive is multi-volume
-	solid    bool                  // is a solid archive
-	keyCache [cacheSize50]struct { // encryption key cache
-		kdfCount int
-		salt     []byte
-		keys     [][]byte
-	}
+	passFn   passwordFunc        // optional function to request a password
+	passes   []string            // passwords to try in order before calling passFn
+	passIdx  int                 // index in passes of pass, or -1
+	mu       *sync.Mutex         // protects password and keys, as keys may be generated lazily
+	blockKey []byte              // key used to encrypt blocks
+	blockAes cipher.Block        // AES cipher for blockKey
+	verified bool                // blockKey is known to be correct
+	maxHdr   int                 // maximum block header size (0 for no limit)
+	strict   bool                // reject unknown file header records and a missing end block
+	lenient  bool                // allow bad header crcs for service blocks
+	multi    bool                // archive is multi-volume
+	solid    bool                // is a solid archive
+	file     *FileHeader         // header of last file, used to store service data
+	qo       map[int64][]byte    // cached block headers from the quick open record, by volume offset
+	meta     []byte              // archive metadata record from the first volume
+	keyCache map[string][][]byte // keys by password, salt and kdf count, shared by clones
+	aesCache cipherCache         // AES ciphers by key, shared by clones
+	dec      Decrypter           // creates decrypters, nil to use crypto/aes
 }
 
+// cipherCache stores AES ciphers by key.
+type cipherCache map[string]cipher.Block
+
 func (a *archive50) clone() fileBlockReader {
 	na := new(archive50)
 	*na = *a
+	na.file = nil
 	return na
 }
 
@@ -174,6 +221,14 @@ func calcKeys50(pass, salt []byte, kdfCount int) [][]byte {
 	return keys
 }
 
+// validPwCheck reports whether the checksum at the end of a stored password
+// check value is correct, so that a corrupt value isn't reported as an
+// incorrect password.
+func validPwCheck(check []byte) bool {
+	sum := sha256.Sum256(check[:pwCheckSize])
+	return bytes.Equal(check[pwCheckSize:], sum[:4])
+}
+
 // getKeys returns the the corresponding encryption keys for the given kdfcount and salt.
 // It will check the password if check is provided.
 func (a *archive50) getKeys(kdfCount int, salt, check []byte) ([][]byte, error) {
@@ -182,53 +237,131 @@ func (a *archive50) getKeys(kdfCount int, salt, check []byte) ([][]byte, error)
 	if kdfCount > maxKdfCount {
 		return nil, ErrCorruptEncryptData
 	}
-	kdfCount = 1 << uint(kdfCount)
-
 	// check cache of keys for match
-	for _, v := range a.keyCache {
-		if kdfCount == v.kdfCount && bytes.Equal(salt, v.salt) {
-			keys = v.keys
-			break
-		}
-	}
+	id := make([]byte, 0, 1+len(salt)+len(a.pass))
+	id = append(id, byte(kdfCount))
+	id = append(id, salt...)
+	id = append(id, a.pass...)
+	kdfCount = 1 << uint(kdfCount)
+	keys = a.keyCache[string(id)]
 	if keys == nil {
 		// not found, calculate keys
 		keys = calcKeys50(a.pass, salt, kdfCount)
 
 		// store in cache
-		copy(a.keyCache[1:], a.keyCache[:])
-		a.keyCache[0].kdfCount = kdfCount
-		a.keyCache[0].salt = append([]byte(nil), salt...)
-		a.keyCache[0].keys = keys
+		if len(a.keyCache) >= cacheSize50 {
+			clear(a.keyCache)
+			clear(a.aesCache)
+		}
+		a.keyCache[string(id)] = keys
 	}
 
-	// check password
-	if check != nil && !bytes.Equal(check, keys[2]) {
+	// check password, in constant time as the check value is derived from it
+	if check != nil && subtle.ConstantTimeCompare(check, keys[2]) != 1 {
 		return nil, ErrBadPassword
 	}
 	return keys, nil
 }
 
+// aesCipher returns the AES cipher for key, which is cached so files and block
+// headers encrypted with the same key don't each have to expand it again.
+func (a *archive50) aesCipher(key []byte) (cipher.Block, error) {
+	if a.dec != nil {
+		// decrypters are created from the key instead
+		return nil, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	block := a.aesCache[string(key)]
+	if block == nil {
+		var err error
+		block, err = aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		a.aesCache[string(key)] = block
+	}
+	return block, nil
+}
+
+// setPassword sets the password. Cached keys are kept as they are stored by password.
+func (a *archive50) setPassword(pass string) {
+	a.pass = []byte(truncPassword(pass))
+}
+
+// requestKeys returns the encryption keys for the given kdfCount and salt.
+// If no password has been set, or check shows the password is incorrect, the
+// passwords in passes are tried in order, then a new password is requested from
+// the password function if available. noPassErr is returned if there is no password.
+func (a *archive50) requestKeys(fh *FileHeader, kdfCount int, salt, check []byte, noPassErr error) ([][]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		if a.pass != nil {
+			keys, err := a.getKeys(kdfCount, salt, check)
+			if err != ErrBadPassword || (attempt >= len(a.passes) && a.passFn == nil) {
+				if err == nil && fh != nil {
+					fh.PasswordIndex = a.passIdx
+				}
+				return keys, err
+			}
+		} else if len(a.passes) == 0 && a.passFn == nil {
+			return nil, noPassErr
+		}
+		if attempt < len(a.passes) {
+			a.setPassword(a.passes[attempt])
+			a.passIdx = attempt
+			continue
+		}
+		pass, err := a.passFn(fh, attempt-len(a.passes))
+		if err != nil {
+			return nil, err
+		}
+		a.setPassword(pass)
+		a.passIdx = -1
+	}
+}
+
 // parseFileEncryptionRecord processes the optional file encryption record from a file header.
 func (a *archive50) parseFileEncryptionRecord(b readBuf, f *fileBlockHeader) error {
 	f.Encrypted = true
-	if ver := b.uvarint(); ver != 0 {
+	ver, err := b.uvarint()
+	if err != nil {
+		return err
+	}
+	if ver != 0 {
 		return ErrUnknownEncryptMethod
 	}
-	flags := b.uvarint()
-	if len(b) < 33 {
+	flags, err := b.uvarint()
+	if err != nil {
+		return err
+	}
+	kdf, err := b.byte()
+	if err != nil {
 		return ErrCorruptEncryptData
 	}
-	kdfCount := int(b.byte())
-	salt := append([]byte(nil), b.bytes(16)...)
-	f.iv = append([]byte(nil), b.bytes(16)...)
+	kdfCount := int(kdf)
+	salt, err := b.bytes(16)
+	if err != nil {
+		return ErrCorruptEncryptData
+	}
+	salt = append([]byte(nil), salt...)
+	iv, err := b.bytes(16)
+	if err != nil {
+		return ErrCorruptEncryptData
+	}
+	f.iv = append([]byte(nil), iv...)
 
 	var check []byte
 	if flags&file5EncCheckPresent > 0 {
-		if len(b) < 12 {
+		if check, err = b.bytes(12); err != nil {
+			return ErrCorruptEncryptData
+		}
+		check = append([]byte(nil), check...)
+		if !validPwCheck(check) {
 			return ErrCorruptEncryptData
 		}
-		check = append([]byte(nil), b.bytes(12)...)
+		f.pwCheck = true
 	}
 	useMac := flags&file5EncUseMac > 0
 	// only need to generate keys for first block or
@@ -237,15 +370,16 @@ func (a *archive50) parseFileEncryptionRecord(b readBuf, f *fileBlockHeader) err
 		return nil
 	}
 	f.genKeys = func() error {
-		if a.pass == nil {
-			return ErrArchivedFileEncrypted
-		}
-		keys, err := a.getKeys(kdfCount, salt, check)
+		keys, err := a.requestKeys(&f.FileHeader, kdfCount, salt, check, ErrArchivedFileEncrypted)
 		if err != nil {
 			return err
 		}
 
 		f.key = keys[0]
+		f.block, err = a.aesCipher(f.key)
+		if err != nil {
+			return err
+		}
 		if useMac {
 			f.hashKey = keys[1]
 		}
@@ -255,28 +389,35 @@ func (a *archive50) parseFileEncryptionRecord(b readBuf, f *fileBlockHeader) err
 }
 
 func readWinFiletime(b *readBuf) (time.Time, error) {
-	if len(*b) < 8 {
+	t, err := b.uint64()
+	if err != nil {
 		return time.Time{}, ErrCorruptFileHeader
 	}
-	// 100-nanosecond intervals since January 1, 1601
-	t := b.uint64() - 116444736000000000
+	return winFiletime(t), nil
+}
+
+// winFiletime converts t, in 100-nanosecond intervals since January 1, 1601, to a time.
+func winFiletime(t uint64) time.Time {
+	t -= 116444736000000000
 	t *= 100
 	sec, nsec := bits.Div64(0, t, uint64(time.Second))
-	return time.Unix(int64(sec), int64(nsec)), nil
+	return time.Unix(int64(sec), int64(nsec))
 }
 
 func readUnixTime(b *readBuf) (time.Time, error) {
-	if len(*b) < 4 {
+	t, err := b.uint32()
+	if err != nil {
 		return time.Time{}, ErrCorruptFileHeader
 	}
-	return time.Unix(int64(b.uint32()), 0), nil
+	return time.Unix(int64(t), 0), nil
 }
 
 func readUnixNanoseconds(b *readBuf) (time.Duration, error) {
-	if len(*b) < 4 {
+	n, err := b.uint32()
+	if err != nil {
 		return 0, ErrCorruptFileHeader
 	}
-	d := time.Duration(b.uint32() & 0x3fffffff)
+	d := time.Duration(n & 0x3fffffff)
 	if d >= time.Second {
 		return 0, ErrCorruptFileHeader
 	}
@@ -285,10 +426,13 @@ func readUnixNanoseconds(b *readBuf) (time.Duration, error) {
 
 // parseFilePrecisionTimeRecord processes the optional high precision time record from a file header.
 func (a *archive50) parseFilePrecisionTimeRecord(b *readBuf, f *fileBlockHeader) error {
-	var err error
-	flags := b.uvarint()
+	flags, err := b.uvarint()
+	if err != nil {
+		return err
+	}
 	isUnixTime := flags&file5ExtraTimeIsUnixTime > 0
 	if flags&file5ExtraTimeHasMTime > 0 {
+		f.StoredTimes |= TimeModification
 		if isUnixTime {
 			f.ModificationTime, err = readUnixTime(b)
 		} else {
@@ -299,6 +443,7 @@ func (a *archive50) parseFilePrecisionTimeRecord(b *readBuf, f *fileBlockHeader)
 		}
 	}
 	if flags&file5ExtraTimeHasCTime > 0 {
+		f.StoredTimes |= TimeCreation
 		if isUnixTime {
 			f.CreationTime, err = readUnixTime(b)
 		} else {
@@ -309,6 +454,7 @@ func (a *archive50) parseFilePrecisionTimeRecord(b *readBuf, f *fileBlockHeader)
 		}
 	}
 	if flags&file5ExtraTimeHasATime > 0 {
+		f.StoredTimes |= TimeAccess
 		if isUnixTime {
 			f.AccessTime, err = readUnixTime(b)
 		} else {
@@ -344,46 +490,168 @@ func (a *archive50) parseFilePrecisionTimeRecord(b *readBuf, f *fileBlockHeader)
 	return nil
 }
 
+// parseFileHashRecord processes the optional file hash record from a file header.
+// The hash isn't checked, but is stored as the file Checksum as it is stronger
+// than any CRC32 also stored.
+func (a *archive50) parseFileHashRecord(b readBuf, f *fileBlockHeader) error {
+	htype, err := b.uvarint()
+	if err != nil {
+		return err
+	}
+	if htype != file5HashBlake2 {
+		return nil // unknown hash type
+	}
+	sum, err := b.bytes(hashSizeBlake2)
+	if err != nil {
+		return ErrCorruptFileHeader
+	}
+	if f.last {
+		f.ChecksumType = ChecksumBLAKE2sp
+		f.Checksum = append([]byte(nil), sum...)
+	}
+	return nil
+}
+
+// parseFileRedirectionRecord processes the optional file redirection record from a file header.
+func (a *archive50) parseFileRedirectionRecord(b readBuf, f *fileBlockHeader) error {
+	rtype, err := b.uvarint()
+	if err != nil {
+		return err
+	}
+	if _, err = b.uvarint(); err != nil { // ignore flags field
+		return err
+	}
+	nlen, err := b.uvarint()
+	if err != nil {
+		return err
+	}
+	target, err := b.bytes(nlen)
+	if err != nil {
+		return ErrCorruptFileHeader
+	}
+	if rtype > file5RedirMax {
+		return nil // unknown redirection type, treat as a normal file
+	}
+	f.RedirectType = int(rtype)
+	f.RedirectTarget = string(target)
+	if f.hasNoData() {
+		// no data stored, so nothing to decode or check
+		f.decVer = 0
+		f.hash = nil
+	}
+	return nil
+}
+
+// parseFileOwnerRecord processes the optional unix owner record from a file header.
+func (a *archive50) parseFileOwnerRecord(b readBuf, f *fileBlockHeader) error {
+	flags, err := b.uvarint()
+	if err != nil {
+		return err
+	}
+	for _, v := range []struct {
+		flag uint64
+		s    *string
+	}{{file5OwnerHasName, &f.Owner}, {file5OwnerHasGroup, &f.Group}} {
+		if flags&v.flag == 0 {
+			continue
+		}
+		n, err := b.uvarint()
+		if err != nil {
+			return err
+		}
+		name, err := b.bytes(n)
+		if err != nil {
+			return ErrCorruptFileHeader
+		}
+		*v.s = string(name)
+	}
+	for _, v := range []struct {
+		flag uint64
+		id   *int
+	}{{file5OwnerHasUID, &f.UID}, {file5OwnerHasGID, &f.GID}} {
+		if flags&v.flag == 0 {
+			continue
+		}
+		id, err := b.uvarint()
+		if err != nil {
+			return err
+		}
+		*v.id = int(id)
+	}
+	return nil
+}
+
 func (a *archive50) parseFileHeader(h *blockHeader50) (*fileBlockHeader, error) {
 	f := new(fileBlockHeader)
+	f.UID, f.GID = -1, -1
+	f.PasswordIndex = -1
 
 	f.HeaderEncrypted = a.blockKey != nil
 	f.first = h.flags&block5DataNotFirst == 0
 	f.last = h.flags&block5DataNotLast == 0
+	f.SplitBefore, f.SplitAfter = !f.first, !f.last
 
-	flags := h.data.uvarint() // file flags
+	flags, err := h.data.uvarint() // file flags
+	if err != nil {
+		return nil, err
+	}
+	f.raw = FileSys{Flags: flags, BlockFlags: h.flags, Format: FormatRAR50}
 	f.IsDir = flags&file5IsDir > 0
 	f.UnKnownSize = flags&file5UnpSizeUnknown > 0
-	f.UnPackedSize = int64(h.data.uvarint())
+	size, err := h.data.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if size > math.MaxInt64 && !f.UnKnownSize {
+		return nil, ErrCorruptFileHeader
+	}
+	f.UnPackedSize = int64(size)
 	f.PackedSize = h.dataSize
-	f.Attributes = int64(h.data.uvarint())
+	attr, err := h.data.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	f.Attributes = int64(attr)
 	if flags&file5HasUnixMtime > 0 {
-		if len(h.data) < 4 {
+		mtime, err := h.data.uint32()
+		if err != nil {
 			return nil, ErrCorruptFileHeader
 		}
-		f.ModificationTime = time.Unix(int64(h.data.uint32()), 0)
+		f.ModificationTime = time.Unix(int64(mtime), 0)
+		f.StoredTimes |= TimeModification
 	}
 	if flags&file5HasCRC32 > 0 {
-		if len(h.data) < 4 {
+		sum, err := h.data.bytes(4)
+		if err != nil {
 			return nil, ErrCorruptFileHeader
 		}
-		f.sum = append([]byte(nil), h.data.bytes(4)...)
+		f.sum = append([]byte(nil), sum...)
 		if f.first {
 			f.hash = newLittleEndianCRC32
 		}
+		if f.last {
+			f.ChecksumType, f.Checksum = ChecksumCRC32, append([]byte(nil), f.sum...)
+		}
 	}
 
-	flags = h.data.uvarint() // compression flags
+	flags, err = h.data.uvarint() // compression flags
+	if err != nil {
+		return nil, err
+	}
+	f.raw.ExtractVersion = int(flags & file5CompAlgorithm)
 	f.Solid = flags&file5CompSolid > 0
 	f.arcSolid = a.solid
 	method := (flags >> 7) & 7 // compression method (0 == none)
+	f.Method = int(method)
 	if f.first && method != 0 {
 		unpackver := flags & file5CompAlgorithm
 		var winSize int64
 		if unpackver == 0 {
 			f.decVer = decode50Ver
+			f.CompressionVersion = 50
 			winSize = 0x20000 << ((flags >> 10) & 0x0F)
 		} else if unpackver == 1 {
+			f.CompressionVersion = 70
 			if flags&file5CompV5Compat > 0 {
 				f.decVer = decode50Ver
 			} else {
@@ -401,8 +669,13 @@ func (a *archive50) parseFileHeader(h *blockHeader50) (*fileBlockHeader, error)
 			return nil, ErrPlatformIntSize
 		}
 		f.winSize = int(winSize)
+		f.DictionarySize = winSize
+	}
+	hostOS, err := h.data.uvarint()
+	if err != nil {
+		return nil, err
 	}
-	switch h.data.uvarint() {
+	switch hostOS {
 	case 0:
 		f.HostOS = HostOSWindows
 	case 1:
@@ -410,29 +683,43 @@ func (a *archive50) parseFileHeader(h *blockHeader50) (*fileBlockHeader, error)
 	default:
 		f.HostOS = HostOSUnknown
 	}
-	nlen := int(h.data.uvarint())
-	if len(h.data) < nlen {
+	nlen, err := h.data.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	name, err := h.data.bytes(nlen)
+	if err != nil {
 		return nil, ErrCorruptFileHeader
 	}
-	f.Name = string(h.data.bytes(nlen))
+	f.Name = string(name)
 
 	// parse optional extra records
 	for _, e := range h.extra {
-		var err error
 		switch e.ftype {
 		case 1: // encryption
 			err = a.parseFileEncryptionRecord(e.data, f)
-		case 2:
-			// TODO: hash
+		case 2: // hash
+			err = a.parseFileHashRecord(e.data, f)
 		case 3:
 			err = a.parseFilePrecisionTimeRecord(&e.data, f)
 		case 4: // version
-			_ = e.data.uvarint() // ignore flags field
-			f.Version = int(e.data.uvarint())
-		case 5:
-			// TODO: redirection
-		case 6:
-			// TODO: owner
+			var ver uint64
+			if _, err = e.data.uvarint(); err == nil { // ignore flags field
+				ver, err = e.data.uvarint()
+				f.Version = int(ver)
+			}
+		case 5: // redirection
+			err = a.parseFileRedirectionRecord(e.data, f)
+		case 6: // unix owner
+			err = a.parseFileOwnerRecord(e.data, f)
+		case 7: // service data
+			if h.htype == block5Service && f.Name == "STM" {
+				f.stream = string(e.data) // UTF-8 stream name
+			}
+		default:
+			if a.strict {
+				err = ErrUnknownRecord
+			}
 		}
 		if err != nil {
 			return nil, err
@@ -443,32 +730,47 @@ func (a *archive50) parseFileHeader(h *blockHeader50) (*fileBlockHeader, error)
 
 // parseEncryptionBlock calculates the key for block encryption.
 func (a *archive50) parseEncryptionBlock(b readBuf) error {
-	if a.pass == nil {
-		return ErrArchiveEncrypted
+	ver, err := b.uvarint()
+	if err != nil {
+		return err
 	}
-	if ver := b.uvarint(); ver != 0 {
+	if ver != 0 {
 		return ErrUnknownEncryptMethod
 	}
-	flags := b.uvarint()
-	if len(b) < 17 {
+	flags, err := b.uvarint()
+	if err != nil {
+		return err
+	}
+	kdf, err := b.byte()
+	if err != nil {
+		return ErrCorruptEncryptData
+	}
+	kdfCount := int(kdf)
+	salt, err := b.bytes(16)
+	if err != nil {
 		return ErrCorruptEncryptData
 	}
-	kdfCount := int(b.byte())
-	salt := b.bytes(16)
 
 	var check []byte
 	if flags&enc5CheckPresent > 0 {
-		if len(b) < 12 {
+		if check, err = b.bytes(12); err != nil {
+			return ErrCorruptEncryptData
+		}
+		if !validPwCheck(check) {
 			return ErrCorruptEncryptData
 		}
-		check = b.bytes(12)
 	}
 
-	keys, err := a.getKeys(kdfCount, salt, check)
+	keys, err := a.requestKeys(nil, kdfCount, salt, check, ErrArchiveEncrypted)
+	if err != nil {
+		return err
+	}
+	a.blockAes, err = a.aesCipher(keys[0])
 	if err != nil {
 		return err
 	}
 	a.blockKey = keys[0]
+	a.verified = check != nil
 	return nil
 }
 
@@ -479,7 +781,9 @@ func (a *archive50) readBlockHeader(r sliceReader) (*blockHeader50, error) {
 		if err != nil {
 			return nil, err
 		}
-		r = newAesSliceReader(r, a.blockKey, iv)
+		if r, err = newAesSliceReader(r, a.dec, a.blockKey, iv, a.blockAes); err != nil {
+			return nil, err
+		}
 	}
 	var b readBuf
 	var err error
@@ -488,11 +792,24 @@ func (a *archive50) readBlockHeader(r sliceReader) (*blockHeader50, error) {
 	if err != nil {
 		return nil, err
 	}
-	crc := b.uint32()
+	crc, err := b.uint32()
+	if err != nil {
+		return nil, err
+	}
 
 	hash := crc32.NewIEEE()
 
-	size := int(b.uvarint()) // header size
+	usize, err := b.uvarint() // header size
+	if err != nil {
+		return nil, err
+	}
+	if usize == 0 || usize > maxHeaderSize50 {
+		return nil, ErrCorruptBlockHeader
+	}
+	size := int(usize)
+	if a.maxHdr > 0 && size > a.maxHdr {
+		return nil, ErrLimitsExceeded
+	}
 	b, err = r.readSlice(7 - len(b) + size)
 	if err != nil {
 		return nil, err
@@ -500,68 +817,350 @@ func (a *archive50) readBlockHeader(r sliceReader) (*blockHeader50, error) {
 
 	// check header crc
 	_, _ = hash.Write(b[4:])
-	if crc != hash.Sum32() {
+	badCRC := crc != hash.Sum32()
+	if badCRC && (!a.lenient || (a.blockKey != nil && !a.verified)) {
 		return nil, ErrBadHeaderCRC
 	}
 
-	b = b[len(b)-size:]
 	h := new(blockHeader50)
-	h.htype = b.uvarint()
-	h.flags = b.uvarint()
+	h.raw = b
+	b = b[len(b)-size:]
+	if h.htype, err = b.uvarint(); err != nil {
+		return nil, err
+	}
+	if h.flags, err = b.uvarint(); err != nil {
+		return nil, err
+	}
+	if badCRC {
+		if h.htype != block5Service {
+			return nil, ErrBadHeaderCRC
+		}
+		h.badCRC = true
+	}
 
-	var extraSize int
+	var extraSize, dataSize uint64
 	if h.flags&block5HasExtra > 0 {
-		extraSize = int(b.uvarint())
+		if extraSize, err = b.uvarint(); err != nil {
+			return nil, err
+		}
 	}
 	if h.flags&block5HasData > 0 {
-		h.dataSize = int64(b.uvarint())
+		if dataSize, err = b.uvarint(); err != nil {
+			return nil, err
+		}
 	}
-	if len(b) < extraSize {
+	if dataSize > math.MaxInt64 || uint64(len(b)) < extraSize {
 		return nil, ErrCorruptBlockHeader
 	}
-	h.data = b.bytes(len(b) - extraSize)
+	h.dataSize = int64(dataSize)
+	if h.data, err = b.next(len(b) - int(extraSize)); err != nil {
+		return nil, err
+	}
 
 	// read header extra records
 	for len(b) > 0 {
-		size = int(b.uvarint())
-		if len(b) < size {
-			return nil, ErrCorruptBlockHeader
+		rsize, err := b.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		rec, err := b.bytes(rsize)
+		if err != nil {
+			return nil, err
+		}
+		data := readBuf(rec)
+		ftype, err := data.uvarint()
+		if err != nil {
+			return nil, err
 		}
-		data := readBuf(b.bytes(size))
-		ftype := data.uvarint()
 		h.extra = append(h.extra, extra{ftype, data})
 	}
 
 	return h, nil
 }
 
+// archiveMetadata returns a copy of the data of the metadata record, containing
+// the archive name and creation time, from the main archive block h.
+// It returns nil if there is no metadata record.
+func archiveMetadata(h *blockHeader50) []byte {
+	for _, e := range h.extra {
+		if e.ftype == arc5ExtraMetadata {
+			return append([]byte{}, e.data...)
+		}
+	}
+	return nil
+}
+
+// sameVolumeSet reports whether the main archive block h, with the given flags,
+// from a volume after the first could belong to the same archive as the
+// previous volumes. Volumes of an archive are all marked as volumes, have the
+// same solid flag, and if stored, the same archive metadata.
+func (a *archive50) sameVolumeSet(flags uint64, h *blockHeader50) bool {
+	if flags&arc5MultiVol == 0 || (flags&arc5Solid > 0) != a.solid {
+		return false
+	}
+	meta := archiveMetadata(h)
+	return a.meta == nil || meta == nil || bytes.Equal(a.meta, meta)
+}
+
+// readQuickOpen reads the quick open record referenced by the locator record of
+// the main archive block h, found at volume offset pos. It returns the cached block
+// headers from the record, keyed by their volume offset. The volume is returned to
+// its original offset afterwards. A missing or invalid quick open record is not an
+// error, as the block headers can still be read from the volume.
+func (a *archive50) readQuickOpen(v *volume, pos int64, h *blockHeader50) (map[int64][]byte, error) {
+	if a.blockKey != nil || !v.seekable() {
+		return nil, nil
+	}
+	var qoPos int64
+	for _, e := range h.extra {
+		if e.ftype != arc5ExtraLocator {
+			continue
+		}
+		// an invalid locator leaves qoPos unset, so the record isn't used
+		if flags, err := e.data.uvarint(); err == nil && flags&locator5QuickOpen > 0 {
+			if off, err := e.data.uvarint(); err == nil && off > 0 && off <= math.MaxInt64-uint64(pos) {
+				qoPos = pos + int64(off)
+			}
+		}
+	}
+	if qoPos <= v.off {
+		return nil, nil
+	}
+	off := v.off
+	if err := v.seek(qoPos); err != nil {
+		return nil, err
+	}
+	qo := a.readQuickOpenData(v, qoPos)
+	return qo, v.seek(off)
+}
+
+// readQuickOpenData reads the quick open service block at the current volume offset
+// qoPos and returns the block headers it contains, or nil if it is invalid.
+func (a *archive50) readQuickOpenData(v *volume, qoPos int64) map[int64][]byte {
+	h, err := a.readBlockHeader(v)
+	if err != nil || h.htype != block5Service {
+		return nil
+	}
+	f, err := a.parseFileHeader(h)
+	if err != nil || f.Name != "QO" || !f.first || !f.last || f.Encrypted || f.UnKnownSize ||
+		f.PackedSize > maxQuickOpenSize || f.UnPackedSize > maxQuickOpenSize {
+		return nil
+	}
+	b, err := readServiceData(v, f)
+	if err != nil {
+		return nil
+	}
+	qo := make(map[int64][]byte)
+	buf := readBuf(b)
+	for len(buf) > 0 {
+		// each cached header is stored in a structure with its own crc
+		crc, err := buf.uint32()
+		if err != nil {
+			return nil
+		}
+		rec := buf
+		size, err := buf.uvarint()
+		if err != nil || size == 0 || size > uint64(len(buf)) {
+			return nil
+		}
+		if crc32.ChecksumIEEE(rec[:len(rec)-len(buf)+int(size)]) != crc {
+			return nil
+		}
+		hdr, err := buf.bytes(size)
+		if err != nil {
+			return nil
+		}
+		s := readBuf(hdr)
+		if _, err = s.uvarint(); err != nil { // flags
+			return nil
+		}
+		off, err := s.uvarint()
+		if err != nil || off == 0 || off > uint64(qoPos) {
+			return nil
+		}
+		hsize, err := s.uvarint()
+		if err != nil || hsize == 0 {
+			return nil
+		}
+		if qo[qoPos-int64(off)], err = s.bytes(hsize); err != nil {
+			return nil
+		}
+	}
+	return qo
+}
+
+// blockTypeName50 returns the name of block type t, used to describe error locations.
+func blockTypeName50(t uint64) string {
+	switch t {
+	case block5Arc:
+		return "archive"
+	case block5File:
+		return "file"
+	case block5Service:
+		return "service"
+	case block5Encrypt:
+		return "encryption"
+	case block5End:
+		return "end"
+	}
+	return "unknown"
+}
+
+// volumeNumber reads the block headers at the start of v, returning its volume
+// number, or -1 if it isn't a volume of a multi-volume archive.
+func (a *archive50) volumeNumber(v *volume) (int, error) {
+	for {
+		h, err := a.readBlockHeader(v)
+		if err != nil {
+			return -1, err
+		}
+		switch h.htype {
+		case block5Encrypt:
+			if err = a.parseEncryptionBlock(h.data); err != nil {
+				return -1, err
+			}
+		case block5Arc:
+			flags, err := h.data.uvarint()
+			if err != nil || flags&arc5MultiVol == 0 {
+				return -1, err
+			}
+			if flags&arc5VolNumber == 0 {
+				return 0, nil
+			}
+			n, err := h.data.uvarint()
+			if err != nil {
+				return -1, err
+			}
+			return int(n), nil
+		default:
+			return -1, ErrCorruptBlockHeader
+		}
+	}
+}
+
+// validHeader reports whether r starts with a valid unencrypted block header.
+func (a *archive50) validHeader(r sliceReader) bool {
+	h, err := a.readBlockHeader(r)
+	return err == nil && h.htype >= block5Arc && h.htype <= block5End
+}
+
 // next advances to the next file block in the archive
 func (a *archive50) next(v *volume) (*fileBlockHeader, error) {
 	for {
-		// get next block header
-		h, err := a.readBlockHeader(v)
+		// get next block header, from the quick open cache if available
+		var h *blockHeader50
+		var err error
+		pos := v.off
+		v.setBlock(pos, "")
+		if b, ok := a.qo[pos]; ok {
+			r := bufSliceReader(b)
+			h, err = a.readBlockHeader(&r)
+			if err == nil {
+				err = v.discard(int64(len(b)))
+			}
+		} else {
+			h, err = a.readBlockHeader(v)
+		}
 		if err != nil {
-			if err == io.EOF {
+			if err == io.EOF && a.strict {
+				err = ErrUnexpectedArcEnd // no end block
+			} else if err == io.EOF {
 				err = io.ErrUnexpectedEOF
 			}
+			if a.blockKey != nil && !a.verified {
+				err = headerPasswordErr(err)
+			} else if a.blockKey == nil && v.skipDamaged(err, a.validHeader) {
+				continue
+			}
+			return nil, err
+		}
+		a.verified = a.blockKey != nil
+		v.blk = blockTypeName50(h.htype)
+		if err = v.reportBlock(int(h.htype), h.raw, h.dataSize); err != nil {
 			return nil, err
 		}
 		switch h.htype {
 		case block5File:
-			return a.parseFileHeader(h)
+			f, err := a.parseFileHeader(h)
+			if (err == ErrCorruptFileHeader || varintErr(err)) && v.opt.skipDmg {
+				derr := err
+				if err = v.discard(h.dataSize); err != nil {
+					return nil, err
+				}
+				v.addDamage(v.boff, derr)
+				continue
+			}
+			if err == nil && f.first {
+				a.file = &f.FileHeader
+			}
+			return f, err
+		case block5Service:
+			if h.badCRC {
+				if f, err := a.parseFileHeader(h); err != nil || !isOptionalService(f.Name) {
+					return nil, ErrBadHeaderCRC
+				}
+				if err = skipBadBlock(v, h.dataSize); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			f, perr := a.parseFileHeader(h)
+			if perr != nil {
+				err = v.discard(h.dataSize) // can't parse, skip over block data
+			} else {
+				addStream(v, a, a.file, f)
+				err = addServiceData(v, a.file, f)
+			}
 		case block5Arc:
-			flags := h.data.uvarint()
+			var flags uint64
+			if flags, err = h.data.uvarint(); err != nil {
+				return nil, err
+			}
+			first := v.num == 0 // first volume read, which may not be volume 0
+			if !first && !a.sameVolumeSet(flags, h) {
+				return nil, ErrWrongVolumeSet
+			}
 			a.multi = flags&arc5MultiVol > 0
 			a.solid = flags&arc5Solid > 0
+			if flags&arc5VolNumber > 0 {
+				var n uint64
+				if n, err = h.data.uvarint(); err != nil {
+					return nil, err
+				}
+				if first && a.multi && n > 0 && n <= math.MaxInt32 {
+					// started reading from a later volume
+					v.num = int(n)
+					v.mid = true
+				} else if n != uint64(v.num) {
+					return nil, ErrBadVolumeNumber
+				}
+			}
+			if first {
+				a.meta = archiveMetadata(h)
+			}
+			v.arc = archiveFlags{
+				solid:    a.solid,
+				locked:   flags&arc5Locked > 0,
+				recovery: flags&arc5Recovery > 0,
+				volume:   a.multi,
+				first:    a.multi && v.num == 0,
+			}
+			a.qo, err = a.readQuickOpen(v, pos, h)
 		case block5Encrypt:
 			err = a.parseEncryptionBlock(h.data)
 		case block5End:
-			flags := h.data.uvarint()
+			var flags uint64
+			if flags, err = h.data.uvarint(); err != nil {
+				return nil, err
+			}
 			if flags&endArc5NotLast == 0 || !a.multi {
-				return nil, io.EOF
+				return nil, v.archiveEnd()
 			}
 			a.blockKey = nil // reset encryption when opening new volume file
-			err = v.next()
+			a.qo = nil
+			if err = v.next(); a.strict && errors.Is(err, fs.ErrNotExist) {
+				err = ErrUnexpectedArcEnd
+			}
 		default:
 			if h.dataSize > 0 {
 				err = v.discard(h.dataSize) // skip over block data
@@ -574,10 +1173,11 @@ func (a *archive50) next(v *volume) (*fileBlockHeader, error) {
 }
 
 // newArchive50 creates a new fileBlockReader for a Version 5 archive.
-func newArchive50(password *string) *archive50 {
-	a := new(archive50)
+func newArchive50(password *string, passFn passwordFunc) *archive50 {
+	a := &archive50{passFn: passFn, passIdx: -1, mu: new(sync.Mutex), keyCache: make(map[string][][]byte),
+		aesCache: make(cipherCache)}
 	if password != nil {
-		a.pass = []byte(*password)
+		a.setPassword(*password)
 	}
 	return a
 }
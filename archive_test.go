@@ -0,0 +1,327 @@
+package rardecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"testing/fstest"
+
+	"github.com/nwaples/rardecode/v2/internal/rartest"
+)
+
+func TestReadBuf(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		read func(b *readBuf) (uint64, error)
+		want uint64
+		err  error
+	}{
+		{"byte", "\x01", func(b *readBuf) (uint64, error) { v, err := b.byte(); return uint64(v), err }, 1, nil},
+		{"byte empty", "", func(b *readBuf) (uint64, error) { v, err := b.byte(); return uint64(v), err }, 0, ErrCorruptBlockHeader},
+		{"uint16", "\x01\x02", func(b *readBuf) (uint64, error) { v, err := b.uint16(); return uint64(v), err }, 0x0201, nil},
+		{"uint16 short", "\x01", func(b *readBuf) (uint64, error) { v, err := b.uint16(); return uint64(v), err }, 0, ErrCorruptBlockHeader},
+		{"uint32", "\x01\x02\x03\x04", func(b *readBuf) (uint64, error) { v, err := b.uint32(); return uint64(v), err }, 0x04030201, nil},
+		{"uint32 short", "\x01\x02\x03", func(b *readBuf) (uint64, error) { v, err := b.uint32(); return uint64(v), err }, 0, ErrCorruptBlockHeader},
+		{"uint64", "\x01\x02\x03\x04\x05\x06\x07\x08", func(b *readBuf) (uint64, error) { return b.uint64() }, 0x0807060504030201, nil},
+		{"uint64 short", "\x01\x02\x03\x04\x05\x06\x07", func(b *readBuf) (uint64, error) { return b.uint64() }, 0, ErrCorruptBlockHeader},
+		{"bytes", "abc", func(b *readBuf) (uint64, error) { v, err := b.bytes(3); return uint64(len(v)), err }, 3, nil},
+		{"bytes short", "abc", func(b *readBuf) (uint64, error) { v, err := b.bytes(4); return uint64(len(v)), err }, 0, ErrCorruptBlockHeader},
+		{"bytes huge", "abc", func(b *readBuf) (uint64, error) { v, err := b.bytes(1<<64 - 1); return uint64(len(v)), err }, 0, ErrCorruptBlockHeader},
+		{"uvarint", "\x81\x01", func(b *readBuf) (uint64, error) { return b.uvarint() }, 0x81, nil},
+		{"uvarint max", "\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01", func(b *readBuf) (uint64, error) { return b.uvarint() }, 1<<64 - 1, nil},
+		{"uvarint truncated", "\x81", func(b *readBuf) (uint64, error) { return b.uvarint() }, 0, ErrVarintTruncated},
+		{"uvarint overflow", "\xff\xff\xff\xff\xff\xff\xff\xff\xff\x02", func(b *readBuf) (uint64, error) { return b.uvarint() }, 0, ErrVarintOverflow},
+	}
+	for _, test := range tests {
+		b := readBuf(test.data + "x")
+		if test.err != nil {
+			b = readBuf(test.data)
+		}
+		v, err := test.read(&b)
+		if v != test.want || err != test.err {
+			t.Errorf("%s: got %#x, %v, want %#x, %v", test.name, v, err, test.want, test.err)
+		}
+		if test.err != nil && len(b) != 0 {
+			t.Errorf("%s: %d bytes left after error", test.name, len(b))
+		} else if test.err == nil && string(b) != "x" {
+			t.Errorf("%s: got %q left, want \"x\"", test.name, b)
+		}
+	}
+}
+
+// testArchive15 returns a RAR 1.5 archive containing blocks.
+func testArchive15(blocks ...[]byte) []byte {
+	blocks = append([][]byte{rartest.Main15(0)}, blocks...)
+	return rartest.Archive(rartest.Sig15, append(blocks, rartest.End15(0))...)
+}
+
+func TestHeaderEdgeCases(t *testing.T) {
+	file50 := func(fn func(f *rartest.File50)) []byte {
+		f := rartest.NewFile50("f", []byte("x"))
+		fn(f)
+		return testArchive50(0, f.Bytes())
+	}
+	file15 := func(fn func(f *rartest.File15)) []byte {
+		f := rartest.NewFile15("f", []byte("x"))
+		fn(f)
+		return testArchive15(f.Bytes())
+	}
+	// fields of a stored RAR 5 file header: flags, size, attributes, compression and host os
+	fields := append(rartest.Uvarint(0), 1, 0, 0, 0)
+	tests := []struct {
+		name  string
+		arc   []byte
+		opts  []Option
+		err   error
+		check func(h *FileHeader) bool
+	}{
+		{name: "rar50 zero length name",
+			arc:   file50(func(f *rartest.File50) { f.Name = "" }),
+			check: func(h *FileHeader) bool { return h.Name == "" }},
+		{name: "rar50 huge attributes",
+			arc:   file50(func(f *rartest.File50) { f.Attributes = 1<<64 - 1 }),
+			check: func(h *FileHeader) bool { return h.Attributes == -1 }},
+		{name: "rar50 size overflows int64",
+			arc: file50(func(f *rartest.File50) { f.Size = 1 << 63 }),
+			err: ErrCorruptFileHeader},
+		{name: "rar50 unknown size",
+			arc:   file50(func(f *rartest.File50) { f.Flags |= 0x8; f.Size = 1<<64 - 1 }),
+			check: func(h *FileHeader) bool { return h.UnKnownSize }},
+		{name: "rar50 padded varints",
+			arc: testArchive50(0, rartest.Block50(rartest.Block50File, 0,
+				append(append(rartest.PaddedUvarint(0, 10), rartest.PaddedUvarint(1, 10)...), 0, 0, 0, 1, 'f'),
+				nil, []byte("x"))),
+			check: func(h *FileHeader) bool { return h.Name == "f" && h.UnPackedSize == 1 }},
+		{name: "rar50 overflowing varint",
+			arc: testArchive50(0, rartest.Block50(rartest.Block50File, 0,
+				append(rartest.Uvarint(0), "\xff\xff\xff\xff\xff\xff\xff\xff\xff\x02"...), nil, []byte("x"))),
+			err: ErrVarintOverflow},
+		{name: "rar50 truncated varint",
+			arc: testArchive50(0, rartest.Block50(rartest.Block50File, 0, []byte{0x80}, nil, []byte("x"))),
+			err: ErrVarintTruncated},
+		{name: "rar50 huge name length",
+			arc: testArchive50(0, rartest.Block50(rartest.Block50File, 0,
+				append(fields, rartest.Uvarint(1<<64-1)...), nil, []byte("x"))),
+			err: ErrCorruptFileHeader},
+		{name: "rar50 name past end of header",
+			arc: testArchive50(0, rartest.Block50(rartest.Block50File, 0,
+				append(fields, 2, 'f'), nil, []byte("x"))),
+			err: ErrCorruptFileHeader},
+		{name: "rar50 4GB dictionary",
+			arc:   file50(func(f *rartest.File50) { f.Compression = 3<<7 | 0xf<<10 }),
+			check: func(h *FileHeader) bool { return h.DictionarySize == 1<<32 }},
+		{name: "rar50 4GB dictionary over limit",
+			arc:  file50(func(f *rartest.File50) { f.Compression = 3<<7 | 0xf<<10 }),
+			opts: []Option{MaxDictionarySize(1 << 20)},
+			err:  ErrDictionaryTooLarge},
+		{name: "rar70 dictionary over 64GB",
+			arc: file50(func(f *rartest.File50) { f.Compression = 1 | 3<<7 | 0x1f<<10 | 0x1f<<15 }),
+			err: ErrDictionaryTooLarge},
+		{name: "rar15 zero length name",
+			arc:   file15(func(f *rartest.File15) { f.Name = "" }),
+			check: func(h *FileHeader) bool { return h.Name == "" }},
+		{name: "rar15 4MB dictionary over limit",
+			arc:  file15(func(f *rartest.File15) { f.Flags = 0x00c0; f.Method = 0x33 }),
+			opts: []Option{MaxDictionarySize(1 << 20)},
+			err:  ErrDictionaryTooLarge},
+		{name: "rar15 unknown size",
+			arc:   file15(func(f *rartest.File15) { f.Size = 1<<32 - 1 }),
+			check: func(h *FileHeader) bool { return h.UnKnownSize && h.UnPackedSize == -1 }},
+		{name: "rar15 truncated header",
+			arc: testArchive15(rartest.Block15(rartest.Block15File, 0, make([]byte, 10), nil)),
+			err: ErrCorruptFileHeader},
+	}
+	for _, test := range tests {
+		r, err := NewReader(bytes.NewReader(test.arc), test.opts...)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		h, err := r.Next()
+		if err == nil && test.check == nil {
+			_, err = io.Copy(io.Discard, r)
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		} else if err == nil && !test.check(h) {
+			t.Errorf("%s: unexpected header %+v", test.name, h)
+		}
+	}
+}
+
+func TestPermissive(t *testing.T) {
+	// badCRC returns block b with its header crc changed
+	badCRC := func(b []byte) []byte {
+		b = append([]byte(nil), b...)
+		b[0] ^= 0xff
+		return b
+	}
+	service50 := func(name string, data []byte) []byte {
+		f := rartest.NewFile50(name, data)
+		f.HeaderType = rartest.Block50Service
+		return badCRC(f.Bytes())
+	}
+	service15 := func(name string, data []byte) []byte {
+		f := rartest.NewFile15(name, data)
+		f.HeaderType = rartest.Block15Service
+		return badCRC(f.Bytes())
+	}
+	file50 := rartest.NewFile50("f", []byte("x")).Bytes()
+	file15 := rartest.NewFile15("f", []byte("x")).Bytes()
+	// oversized has a block with more data than the rest of the volume, followed by a file
+	oversized := testArchive50(0, service50("CMT", make([]byte, 100)), file50)
+	oversized = append(oversized[:len(oversized)-110], oversized[len(oversized)-10:]...)
+	tests := []struct {
+		name string
+		arc  []byte
+		err  error
+	}{
+		{"rar50 comment", testArchive50(0, service50("CMT", []byte("comment")), file50), nil},
+		{"rar50 unknown service", testArchive50(0, service50("XYZ", []byte("data")), file50), ErrBadHeaderCRC},
+		{"rar50 file", testArchive50(0, badCRC(file50)), ErrBadHeaderCRC},
+		{"rar50 data past end of volume", oversized, ErrBadHeaderCRC},
+		{"rar15 comment", testArchive15(badCRC(rartest.Block15(0x75, 0, make([]byte, 6), nil)), file15), nil},
+		{"rar15 service", testArchive15(service15("CMT", []byte("comment")), file15), nil},
+		{"rar15 unknown service", testArchive15(service15("XYZ", []byte("data")), file15), ErrBadHeaderCRC},
+		{"rar15 unknown block", testArchive15(badCRC(rartest.Block15(0x70, 0, nil, nil)), file15), ErrBadHeaderCRC},
+		{"rar15 file", testArchive15(badCRC(file15)), ErrBadHeaderCRC},
+	}
+	for _, test := range tests {
+		r, err := NewReader(bytes.NewReader(test.arc), Permissive(true))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		h, err := r.Next()
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		} else if err == nil && (h.Name != "f" || len(r.DamagedRegions()) != 1) {
+			t.Errorf("%s: got file %q, %d damaged regions", test.name, h.Name, len(r.DamagedRegions()))
+		}
+	}
+}
+
+func TestStrictArchiveEnd(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		arc  []byte
+	}{
+		{"rar50", rartest.Archive(rartest.Sig50, rartest.Main50(0), rartest.NewFile50("f", []byte("x")).Bytes())},
+		{"rar15", rartest.Archive(rartest.Sig15, rartest.Main15(0), rartest.NewFile15("f", []byte("x")).Bytes())},
+	} {
+		// opened by name, so RAR 1.5 looks for a next volume at the end of the file
+		fsys := fstest.MapFS{"a.rar": &fstest.MapFile{Data: test.arc}}
+		r, err := OpenReader("a.rar", FileSystem(fsys), Strict(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if _, err = r.Next(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if _, err = r.Next(); !errors.Is(err, ErrUnexpectedArcEnd) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, ErrUnexpectedArcEnd)
+		}
+	}
+}
+
+func TestRejectUnsafeNames(t *testing.T) {
+	arc := testArchive50(0,
+		rartest.NewFile50("../evil", testData(1000, 1)).Bytes(),
+		rartest.NewFile50("good", []byte("good data")).Bytes(),
+	)
+	r, err := NewReader(bytes.NewReader(arc), RejectUnsafeNames(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unsafe *UnsafeNameError
+	if _, err = r.Next(); !errors.As(err, &unsafe) {
+		t.Fatalf("got %v, want *UnsafeNameError", err)
+	}
+	// the rejected file's data is skipped
+	h, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(r); h.Name != "good" || err != nil || string(b) != "good data" {
+		t.Fatalf("got %q with %q, %v", h.Name, b, err)
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	bad := rartest.NewFile50("bad", testData(1000, 1))
+	bad.Size++ // stored sizes differ
+	arc := testArchive50(0, bad.Bytes(), rartest.NewFile50("good", []byte("good data")).Bytes())
+	r, err := NewReader(bytes.NewReader(arc), Strict(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Next(); !errors.Is(err, ErrCorruptFileHeader) {
+		t.Fatalf("got %v, want %v", err, ErrCorruptFileHeader)
+	}
+	// the rejected file's data is skipped
+	h, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(r); h.Name != "good" || err != nil || string(b) != "good data" {
+		t.Fatalf("got %q with %q, %v", h.Name, b, err)
+	}
+
+	// Strict(false) keeps an earlier StrictArchiveEnd
+	arc = append(testArchive50(0, rartest.NewFile50("f", []byte("x")).Bytes()), "trailing"...)
+	r, err = NewReader(bytes.NewReader(arc), StrictArchiveEnd(true), Strict(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Next(); !errors.Is(err, ErrTrailingData) {
+		t.Errorf("StrictArchiveEnd: got %v, want %v", err, ErrTrailingData)
+	}
+}
+
+func TestRecoveryRecords(t *testing.T) {
+	fields := binary.LittleEndian.AppendUint32(nil, 1024) // data size
+	fields = append(fields, 1)                            // version
+	fields = binary.LittleEndian.AppendUint16(fields, 2)  // recovery sectors
+	fields = binary.LittleEndian.AppendUint32(fields, 40) // protected sectors
+	fields = append(fields, "Protect!"...)
+	protect := rartest.Block15(0x78, 0, fields, make([]byte, 1024))
+	rr := rartest.NewFile15("RR", make([]byte, 600))
+	rr.HeaderType = rartest.Block15Service
+	rr.Extra = binary.LittleEndian.AppendUint32([]byte("Protect+"), 3)
+	arc := testArchive15(rartest.NewFile15("f", []byte("x")).Bytes(), protect, rr.Bytes())
+	r, err := NewReader(bytes.NewReader(arc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err = r.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	off := int64(len(rartest.Sig15) + len(rartest.Main15(0)))
+	off += int64(len(rartest.NewFile15("f", []byte("x")).Bytes()))
+	want := []RecoveryRecord{
+		{Offset: off, Size: 1024, Sectors: 2, TotalBlocks: 40},
+		{Offset: off + int64(len(protect)), Size: 600, Sectors: 3},
+	}
+	got := r.RecoveryRecords()
+	if len(got) != len(want) {
+		t.Fatalf("got %d recovery records, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("record %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
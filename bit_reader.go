@@ -107,9 +107,11 @@ func (r *replaceByteReader) bytes() ([]byte, error) {
 
 // rarBitReader wraps an io.ByteReader to perform various bit and byte
 // reading utility functions used in RAR file processing.
+// It must be created by newRarBitReader.
 type rarBitReader struct {
 	r byteReader
 	v int
+	l int // number of bytes (not cached) that can be read from r, or -1 for no limit
 	n uint8
 	b []byte
 }
@@ -118,9 +120,55 @@ func (r *rarBitReader) reset(br byteReader) {
 	r.r = br
 	r.n = 0
 	r.v = 0
+	r.l = -1
 	r.b = nil
 }
 
+// setLimit sets the maximum number of bytes that can be read from the
+// underlying byteReader. Reads past the limit return io.EOF.
+func (r *rarBitReader) setLimit(n int) {
+	r.l = n
+}
+
+// fill replaces the bytes buffer with the next bytes from r.
+func (r *rarBitReader) fill() error {
+	if r.r == nil {
+		return ErrDecoderOutOfData
+	}
+	if r.l == 0 {
+		// reached byte limit
+		return io.EOF
+	}
+	var err error
+	r.b, err = r.r.bytes()
+	if err != nil {
+		return err
+	}
+	if r.l > 0 {
+		if len(r.b) > r.l {
+			r.b = r.b[:r.l]
+		}
+		r.l -= len(r.b)
+	}
+	return nil
+}
+
+// available reports whether n more bytes can be read, counting a partly read
+// byte in the bit cache. Bytes read from r to check are kept in the buffer.
+func (r *rarBitReader) available(n int) bool {
+	n -= int(r.n+7)/8 + len(r.b)
+	for n > 0 {
+		b := r.b
+		if err := r.fill(); err != nil {
+			r.b = b
+			return false
+		}
+		n -= len(r.b)
+		r.b = append(b[:len(b):len(b)], r.b...)
+	}
+	return true
+}
+
 // unshiftBytes moves any bytes in rarBitReader bit cache back into a byte slice
 // and sets up byteReader's so that all bytes can now be read by ReadByte() without
 // going through the bit cache.
@@ -147,6 +195,10 @@ func (r *rarBitReader) unshiftBytes() {
 	// on replaceByteReader which will return the old bytes buffer and
 	// replace itself with the old byteReader in rarBitReader.
 	r.r = &replaceByteReader{rp: &r.r, r: r.r, b: r.b}
+	if r.l >= 0 {
+		// the old bytes buffer will be counted again when it is returned
+		r.l += len(r.b)
+	}
 	r.b = b
 }
 
@@ -155,9 +207,7 @@ func (r *rarBitReader) unshiftBytes() {
 func (r *rarBitReader) readBits(n uint8) (int, error) {
 	for n > r.n {
 		if len(r.b) == 0 {
-			var err error
-			r.b, err = r.r.bytes()
-			if err != nil {
+			if err := r.fill(); err != nil {
 				return 0, err
 			}
 		}
@@ -223,12 +273,7 @@ func (r *rarBitReader) readUint32() (uint32, error) {
 // Current bit offsets are ignored.
 func (r *rarBitReader) ReadByte() (byte, error) {
 	if len(r.b) == 0 {
-		if r.r == nil {
-			return 0, io.EOF
-		}
-		var err error
-		r.b, err = r.r.bytes()
-		if err != nil {
+		if err := r.fill(); err != nil {
 			return 0, err
 		}
 	}
@@ -255,5 +300,5 @@ func (r *rarBitReader) readFull(p []byte) error {
 }
 
 func newRarBitReader(r byteReader) *rarBitReader {
-	return &rarBitReader{r: r}
+	return &rarBitReader{r: r, l: -1}
 }
@@ -0,0 +1,69 @@
+package rardecode
+
+import "io"
+
+// Block describes a block header read from an archive volume.
+type Block struct {
+	Type       string // block type name, eg. "archive", "file", "service" or "end"
+	HeaderType int    // block type as stored in the header, specific to the archive format
+	Format     int    // archive format, FormatRAR15 or FormatRAR50
+	Volume     int    // volume number, starting at 0
+	Offset     int64  // offset of the block header in the volume
+	Header     []byte // header bytes as stored, decrypted if headers are encrypted
+	DataOffset int64  // offset of the block data in the volume
+	DataSize   int64  // size of the block data
+}
+
+// BlockFunc is the type of function called by WalkBlocks for each block.
+type BlockFunc func(b *Block) error
+
+// reportBlock calls the BlockFunc set by WalkBlocks, if any, for the block
+// header just read. raw is the header as stored and dataSize the size of the
+// data following it.
+func (v *volume) reportBlock(htype int, raw []byte, dataSize int64) error {
+	if v.opt.blkFn == nil {
+		return nil
+	}
+	b := &Block{
+		Type:       v.blk,
+		HeaderType: htype,
+		Format:     v.ver,
+		Volume:     v.num,
+		Offset:     v.boff,
+		Header:     append([]byte(nil), raw...),
+		DataOffset: v.off,
+		DataSize:   dataSize,
+	}
+	return v.opt.blkFn(b)
+}
+
+// WalkBlocks reads the archive from r and calls fn for each block header in
+// the order they are stored, without decoding any file data. The remaining
+// volumes of a multi-volume archive are read if the VolumeProvider option
+// is used. Walking stops at the first error returned by fn or encountered
+// reading the archive, and returns that error.
+func WalkBlocks(r io.Reader, fn BlockFunc, opts ...Option) error {
+	var ferr error // error returned by fn
+	opts = append(opts[:len(opts):len(opts)], func(o *option) {
+		o.hdrOnly = true
+		o.blkFn = func(b *Block) error {
+			ferr = fn(b)
+			return ferr
+		}
+	})
+	pr, err := newPackedFileReader(r, opts)
+	if err != nil {
+		return err
+	}
+	for {
+		_, err = pr.next()
+		switch {
+		case err == io.EOF:
+			return nil
+		case ferr != nil:
+			return ferr
+		case err != nil:
+			return pr.v.wrapErr(err, "")
+		}
+	}
+}
@@ -128,6 +128,32 @@ func (d *decoder20) readBlockHeader() error {
 	return err
 }
 
+// readLastTables reads a block header that follows the end of the current file.
+// The data for a file in a solid archive can end with the symbol for the end
+// of a block, followed by the new tables needed to decode the next file.
+// As in unrar, they are only read if at least 5 bytes of data remain.
+// Nothing is returned on error, as the file has already been decoded.
+func (d *decoder20) readLastTables() {
+	if !d.br.available(5) {
+		return
+	}
+	if d.hdrRead {
+		var sym int
+		var err error
+		end := 269
+		if d.isAudio {
+			sym, err = d.audio.decoders[d.audio.curChan].readSym(d.br)
+			end = 256
+		} else {
+			sym, err = d.lz.mainDecoder.readSym(d.br)
+		}
+		if err != nil || sym != end {
+			return
+		}
+	}
+	_ = d.readBlockHeader()
+}
+
 func (d *decoder20) fill(dr *decodeReader) error {
 	for d.size > 0 && dr.notFull() {
 		if !d.hdrRead {
@@ -145,14 +171,16 @@ func (d *decoder20) fill(dr *decodeReader) error {
 		d.size -= n
 		switch err {
 		case nil:
-			continue
 		case errEndOfBlock:
 			d.hdrRead = false
-			continue
 		case io.EOF:
-			err = ErrDecoderOutOfData
+			return ErrDecoderOutOfData
+		default:
+			return err
+		}
+		if d.size == 0 {
+			d.readLastTables()
 		}
-		return err
 	}
 	if d.size == 0 {
 		return io.EOF
@@ -0,0 +1,88 @@
+package rardecode
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/nwaples/rardecode/v2/internal/rartest"
+)
+
+// audioData returns n bytes of interleaved samples for chans channels.
+func audioData(n, chans int) []byte {
+	b := make([]byte, n)
+	x := uint32(1)
+	for i := range b {
+		x = x*1664525 + 1013904223
+		c := i % chans
+		b[i] = byte(i/chans*(c+1) + c*64 + int(x>>29))
+	}
+	return b
+}
+
+func TestDecode20Solid(t *testing.T) {
+	var e encoder20
+	var files, packed [][]byte
+	var data []byte
+	add := func(b []byte) {
+		data = append(data, b...)
+		e.write(b)
+	}
+	next := func() {
+		files = append(files, data)
+		packed = append(packed, e.bytes())
+		data = nil
+	}
+
+	// the tables for the next file follow the end of block
+	e.lzBlock()
+	add(testData(5000, 1))
+	e.endBlock()
+	e.audioBlock(2)
+	next()
+	// the audio block ends within the file
+	add(audioData(4000, 2))
+	e.endBlock()
+	e.lzBlock()
+	add(testData(3000, 2))
+	next()
+	// fewer than 5 bytes follow the file, so they aren't read as tables
+	add(testData(2000, 3))
+	e.endBlock()
+	e.w.write(3, 2) // audio block, keep tables
+	next()
+	add(testData(2500, 4))
+	next()
+
+	blocks := [][]byte{rartest.Main15(0x0008)} // solid archive
+	for i, data := range files {
+		f := rartest.NewFile15(string(rune('a'+i)), data)
+		f.Version = 20
+		f.Method = 0x33
+		if i > 0 {
+			f.Flags = 0x0010 // solid
+		}
+		f.Data = packed[i]
+		blocks = append(blocks, f.Bytes())
+	}
+	arc := rartest.Archive(rartest.Sig15, append(blocks, rartest.End15(0))...)
+	r, err := NewReader(bytes.NewReader(arc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range files {
+		if _, err = r.Next(); err != nil {
+			t.Fatalf("file %d: %v", i, err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("file %d: %v", i, err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatalf("file %d: data mismatch", i)
+		}
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+}
@@ -30,6 +30,7 @@ type decoder29 struct {
 	fnum    int        // current filter number (index into filters)
 	flen    []int      // filter block length history
 	filters []v3Filter // list of current filters used by archive encoding
+	fnames  []string   // names of the filters in filters
 
 	lz  *lz29Decoder  // lz decoder
 	ppm *ppm29Decoder // ppm decoder
@@ -39,11 +40,7 @@ func (d *decoder29) version() int { return decode29Ver }
 
 // init intializes the decoder for decoding a new file.
 func (d *decoder29) init(r byteReader, reset bool, size int64, ver int) {
-	if d.br == nil {
-		d.br = newRarBitReader(r)
-	} else {
-		d.br.reset(r)
-	}
+	d.br.reset(r)
 	d.eof = false
 	if reset {
 		d.initFilters()
@@ -57,10 +54,15 @@ func (d *decoder29) init(r byteReader, reset bool, size int64, ver int) {
 	}
 }
 
+func newDecoder29(r byteReader) *decoder29 {
+	return &decoder29{br: newRarBitReader(r)}
+}
+
 func (d *decoder29) initFilters() {
 	d.fnum = 0
 	d.flen = nil
 	d.filters = nil
+	d.fnames = nil
 }
 
 // readVMCode reads the raw bytes for the code/commands used in a vm filter
@@ -88,9 +90,10 @@ func readVMCode(br *rarBitReader) ([]byte, error) {
 	return buf, nil
 }
 
-func (d *decoder29) parseVMFilter(buf []byte) (*filterBlock, error) {
+func (d *decoder29) parseVMFilter(buf []byte, lim decodeLimits, stats *Metrics) (*filterBlock, error) {
 	flags := buf[0]
 	br := newRarBitReader(newBufByteReader(buf[1:]))
+	br.setLimit(len(buf) - 1) // filter fields can't extend past the filter data
 	fb := new(filterBlock)
 
 	// Find the filter number which is an index into d.filters.
@@ -162,11 +165,12 @@ func (d *decoder29) parseVMFilter(buf []byte) (*filterBlock, error) {
 		if err != nil {
 			return nil, err
 		}
-		f, err := getV3Filter(code)
+		f, err := getV3Filter(code, lim, stats)
 		if err != nil {
 			return nil, err
 		}
 		d.filters = append(d.filters, f)
+		d.fnames = append(d.fnames, v3FilterName(code))
 		d.flen = append(d.flen, fb.length)
 	}
 
@@ -189,6 +193,7 @@ func (d *decoder29) parseVMFilter(buf []byte) (*filterBlock, error) {
 
 	// create filter function
 	f := d.filters[d.fnum]
+	fb.name = d.fnames[d.fnum]
 	fb.filter = func(buf []byte, offset int64) ([]byte, error) {
 		return f(r, g, buf, offset)
 	}
@@ -197,7 +202,8 @@ func (d *decoder29) parseVMFilter(buf []byte) (*filterBlock, error) {
 }
 
 // readBlockHeader determines and initializes the current decoder for a new decode block.
-func (d *decoder29) readBlockHeader() error {
+// maxPPM is the maximum memory in bytes a ppm model may use (0 for no limit).
+func (d *decoder29) readBlockHeader(maxPPM int) error {
 	d.br.alignByte()
 	n, err := d.br.readBits(1)
 	if err == nil {
@@ -206,7 +212,7 @@ func (d *decoder29) readBlockHeader() error {
 			if d.ppm == nil {
 				d.ppm = newPPM29Decoder()
 			}
-			err = d.ppm.init(d.br)
+			err = d.ppm.init(d.br, maxPPM)
 		} else {
 			d.isPPM = false
 			if d.lz == nil {
@@ -230,9 +236,12 @@ func (d *decoder29) fill(dr *decodeReader) error {
 	for dr.notFull() {
 		var err error
 		if !d.hdrRead {
-			if err = d.readBlockHeader(); err != nil {
+			if err = d.readBlockHeader(dr.lim.ppmMem); err != nil {
 				return err
 			}
+			if d.isPPM && dr.stats != nil {
+				dr.stats.PPMBytes.Add(int64(d.ppm.mem))
+			}
 		}
 		var b []byte
 		if d.isPPM {
@@ -243,7 +252,7 @@ func (d *decoder29) fill(dr *decodeReader) error {
 		if len(b) > 0 && err == nil {
 			// parse raw data for filter and add to list of filters
 			var f *filterBlock
-			f, err = d.parseVMFilter(b)
+			f, err = d.parseVMFilter(b, dr.lim, dr.stats)
 			if f != nil {
 				err = dr.queueFilter(f)
 			}
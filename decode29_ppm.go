@@ -3,10 +3,11 @@ package rardecode
 type ppm29Decoder struct {
 	m   model // ppm model
 	esc byte  // escape character
+	mem int   // bytes of model memory allocated by the last init, 0 if the model was kept
 	br  *rarBitReader
 }
 
-func (d *ppm29Decoder) init(br *rarBitReader) error {
+func (d *ppm29Decoder) init(br *rarBitReader, maxMem int) error {
 	maxOrder, err := br.readBits(7)
 	if err != nil {
 		return err
@@ -20,6 +21,7 @@ func (d *ppm29Decoder) init(br *rarBitReader) error {
 	d.br = br
 
 	var maxMB int
+	d.mem = 0
 	if reset {
 		var c byte
 		c, err = d.br.ReadByte()
@@ -27,6 +29,10 @@ func (d *ppm29Decoder) init(br *rarBitReader) error {
 			return err
 		}
 		maxMB = int(c) + 1
+		if maxMem > 0 && maxMB<<20 > maxMem {
+			return ErrPPMMemoryTooLarge
+		}
+		d.mem = maxMB << 20
 	}
 
 	if maxOrder&0x40 > 0 {
@@ -141,7 +147,7 @@ func newPPM29Decoder() *ppm29Decoder {
 	ppm := new(ppm29Decoder)
 	ppm.reset()
 	ppm.m.maxOrder = 2
-	ppm.m.a.init(1)
+	_ = ppm.m.a.init(1)
 
 	return ppm
 }
@@ -178,12 +178,16 @@ func (d *decoder50) readFilter(dr *decodeReader) error {
 			return err
 		}
 		fb.filter = func(buf []byte, offset int64) ([]byte, error) { return filterDelta(n+1, buf) }
+		fb.name = "delta"
 	case 1:
 		fb.filter = func(buf []byte, offset int64) ([]byte, error) { return filterE8(0xe8, true, buf, offset) }
+		fb.name = "e8"
 	case 2:
 		fb.filter = func(buf []byte, offset int64) ([]byte, error) { return filterE8(0xe9, true, buf, offset) }
+		fb.name = "e8e9"
 	case 3:
 		fb.filter = filterArm
+		fb.name = "arm"
 	default:
 		return ErrUnknownFilter
 	}
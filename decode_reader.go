@@ -11,6 +11,10 @@ var (
 	ErrTooManyFilters   = errors.New("rardecode: too many filters")
 	ErrInvalidFilter    = errors.New("rardecode: invalid filter")
 	ErrMultipleDecoders = errors.New("rardecode: multiple decoders in a single archive not supported")
+	ErrVMLimitExceeded  = errors.New("rardecode: vm filter limits exceeded")
+	ErrVMFilterDisabled = errors.New("rardecode: vm filters disabled")
+
+	errReleased = errors.New("rardecode: read from closed reader")
 )
 
 // filter functions take a byte slice, the current output offset and
@@ -22,6 +26,7 @@ type filterBlock struct {
 	length int    // length of block
 	offset int    // bytes to be read before start of block
 	filter filter // filter function
+	name   string // filter name reported in FilterInfo
 }
 
 // decoder is the interface for decoding compressed data
@@ -31,6 +36,15 @@ type decoder interface {
 	version() int                                       // decoder version
 }
 
+// decodeLimits restricts the resources used by a decoder. Zero values use the
+// decoder defaults.
+type decodeLimits struct {
+	ppmMem int  // maximum ppm model memory in bytes
+	vmCmds int  // maximum number of vm instructions run by a filter
+	vmOut  int  // maximum vm filter output size
+	noVM   bool // return ErrVMFilterDisabled for non-standard vm filters
+}
+
 // decodeReader implements io.Reader for decoding compressed data in RAR archives.
 type decodeReader struct {
 	tot    int64          // total bytes read from window
@@ -39,18 +53,30 @@ type decodeReader struct {
 	fl     []*filterBlock // list of filters each with offset relative to previous in list
 	dec    decoder        // decoder being used to unpack file
 	err    error          // current decoder error output
+	lim    decodeLimits   // decoder resource limits
+	newArc bool           // a new archive was started, so dec may be replaced
 	br     byteReader
 
 	win  []byte // sliding window buffer
 	size int    // win length
 	r    int    // index in win for reads (beginning)
 	w    int    // index in win for writes (end)
+	ext  []byte // caller provided window used instead of one from the pool
+	rem  int    // length of a copy cut off by the end of win, finished after wrapping
+	roff int    // offset of the copy cut off by the end of win
+
+	dir  *string     // if set, directory for a disk window used instead of one from the pool
+	disk *diskWindow // disk window backing win
+
+	fltFn func(f *FilterInfo) // called for each filter applied, if set
+	stats *Metrics            // counts resources used, if set
 }
 
 func (d *decodeReader) init(r byteReader, ver int, size int, reset bool, unPackedSize int64) error {
 	d.outbuf = nil
 	d.tot = 0
 	d.err = nil
+	d.rem = 0
 	if reset {
 		d.fl = nil
 	}
@@ -58,8 +84,26 @@ func (d *decodeReader) init(r byteReader, ver int, size int, reset bool, unPacke
 
 	// initialize window
 	size = max(size, minWindowSize)
-	if size > len(d.win) {
-		b := make([]byte, size)
+	if size > len(d.win) || (reset && d.disk != nil) {
+		// a disk window is replaced rather than cleared, so it stays sparse
+		var b []byte
+		var disk *diskWindow
+		if d.ext != nil {
+			if size > len(d.ext) {
+				return ErrDictionaryTooLarge
+			}
+			b = d.ext
+			clear(b)
+			size = len(b)
+		} else if d.dir != nil {
+			var err error
+			if disk, err = newDiskWindow(*d.dir, size); err != nil {
+				return err
+			}
+			b = disk.b
+		} else {
+			b = getWindow(size)
+		}
 		if reset {
 			d.w = 0
 		} else if len(d.win) > 0 {
@@ -67,7 +111,12 @@ func (d *decodeReader) init(r byteReader, ver int, size int, reset bool, unPacke
 			n += copy(b[n:], d.win[:d.w])
 			d.w = n
 		}
+		if d.stats != nil && d.ext == nil {
+			d.stats.WindowBytes.Add(int64(len(b)))
+		}
+		d.freeWindow()
 		d.win = b
+		d.disk = disk
 		d.size = size
 	} else if reset {
 		clear(d.win[:])
@@ -76,10 +125,19 @@ func (d *decodeReader) init(r byteReader, ver int, size int, reset bool, unPacke
 	d.r = d.w
 
 	// initialize decoder
+	dver := ver
+	if ver == decode70Ver {
+		dver = decode50Ver // decoder50 decodes both versions
+	}
+	if d.newArc && d.dec != nil && d.dec.version() != dver {
+		d.releaseDecoder()
+		d.dec = nil
+	}
+	d.newArc = false
 	if d.dec == nil {
 		switch ver {
 		case decode29Ver:
-			d.dec = new(decoder29)
+			d.dec = newDecoder29(r)
 		case decode50Ver, decode70Ver:
 			d.dec = new(decoder50)
 		case decode20Ver:
@@ -87,13 +145,53 @@ func (d *decodeReader) init(r byteReader, ver int, size int, reset bool, unPacke
 		default:
 			return ErrUnknownDecoder
 		}
-	} else if d.dec.version() != ver {
+	} else if d.dec.version() != dver {
 		return ErrMultipleDecoders
 	}
 	d.dec.init(r, reset, unPackedSize, ver)
 	return nil
 }
 
+// release returns the window and any decoder memory to their pools.
+// Reads will fail until the decodeReader is initialized again.
+func (d *decodeReader) release() {
+	d.freeWindow()
+	d.win = nil
+	d.disk = nil
+	d.size = 0
+	d.r = 0
+	d.w = 0
+	d.outbuf = nil
+	d.err = errReleased
+	d.releaseDecoder()
+}
+
+// freeWindow returns the window to its pool, or removes it if it is a disk
+// window. A caller provided window is left unchanged.
+func (d *decodeReader) freeWindow() {
+	if d.disk != nil {
+		d.disk.close()
+	} else if d.ext == nil {
+		putWindow(d.win)
+	}
+}
+
+// releaseDecoder returns any memory used by the decoder to its pool.
+func (d *decodeReader) releaseDecoder() {
+	if dec, ok := d.dec.(*decoder29); ok && dec.ppm != nil {
+		dec.ppm.m.release()
+	}
+}
+
+// reset prepares d for decoding files from a new archive. The window and
+// decoder are kept for reuse, but the decoder is replaced if the new archive
+// uses a different version.
+func (d *decodeReader) reset() {
+	d.fl = nil
+	d.outbuf = nil
+	d.newArc = true
+}
+
 // notFull returns if the window is not full
 func (d *decodeReader) notFull() bool { return d.w < d.size }
 
@@ -104,12 +202,17 @@ func (d *decodeReader) writeByte(c byte) {
 }
 
 // copyBytes copies len bytes at off distance from the end
-// to the end of the window.
+// to the end of the window. If the end of the window is reached first, the
+// rest of the copy is made by fill after the window wraps.
 func (d *decodeReader) copyBytes(length, offset int) {
 	length %= d.size
 	if length < 0 {
 		length += d.size
 	}
+	if n := d.w + length - d.size; n > 0 {
+		d.rem, d.roff = n, offset
+		length -= n
+	}
 
 	i := (d.w - offset) % d.size
 	if i < 0 {
@@ -134,11 +237,17 @@ func (d *decodeReader) copyBytes(length, offset int) {
 		d.w += n
 		return
 	}
-	for length > 0 && d.w < d.size {
-		d.win[d.w] = d.win[i]
-		d.w++
-		i++
-		length--
+	// The source overlaps the destination, so the bytes from i repeat every
+	// d.w-i bytes. Copying all the bytes written since i doubles the size of
+	// each chunk, which also handles runs of a single byte (offset 1) quickly.
+	end := min(d.w+length, d.size)
+	if i == d.w {
+		// offset is the window size, so the bytes are already in place
+		d.w = end
+		return
+	}
+	for d.w < end {
+		d.w += copy(d.win[d.w:end], d.win[i:d.w])
 	}
 }
 
@@ -167,12 +276,17 @@ func (d *decodeReader) queueFilter(f *filterBlock) error {
 		f.length += d.size
 	}
 	d.fl = append(d.fl, f)
+	if d.stats != nil {
+		d.stats.FiltersQueued.Add(1)
+	}
 	return nil
 }
 
 func (d *decodeReader) readErr() error {
 	err := d.err
-	d.err = nil
+	if err != errReleased {
+		d.err = nil
+	}
 	return err
 }
 
@@ -185,8 +299,16 @@ func (d *decodeReader) fill() error {
 		// wrap to beginning of buffer
 		d.r = 0
 		d.w = 0
+		if d.rem > 0 {
+			n := d.rem
+			d.rem = 0
+			d.copyBytes(n, d.roff)
+		}
 	}
 	d.err = d.dec.fill(d) // fill window using decoder
+	if d.stats != nil {
+		d.stats.DecodedBytes.Add(int64(d.w - d.r))
+	}
 	if d.w == d.r {
 		return d.readErr()
 	}
@@ -194,9 +316,11 @@ func (d *decodeReader) fill() error {
 }
 
 // bufBytes returns n bytes from the window in a new buffer.
+// The buffer is reused by later calls, and has enough spare capacity for
+// filters that don't work in place, like delta, to store their output.
 func (d *decodeReader) bufBytes(n int) ([]byte, error) {
-	if cap(d.buf) < n {
-		d.buf = make([]byte, n)
+	if cap(d.buf) < 2*n {
+		d.buf = make([]byte, n, 2*n)
 	}
 	// copy into buffer
 	ns := 0
@@ -227,6 +351,9 @@ func (d *decodeReader) processFilters() ([]byte, error) {
 	}
 	for {
 		d.fl = d.fl[1:]
+		if d.fltFn != nil {
+			d.fltFn(&FilterInfo{Name: f.name, Offset: d.tot, Length: len(b)})
+		}
 		// run filter passing buffer and total bytes read so far
 		b, err = f.filter(b, d.tot)
 		if err != nil {
@@ -246,11 +373,22 @@ func (d *decodeReader) processFilters() ([]byte, error) {
 		if f.length != len(b) {
 			return nil, ErrInvalidFilter
 		}
+		if len(b) > 0 && cap(b) < 2*len(b) && cap(d.buf) >= 2*len(b) {
+			// Output is in the spare capacity of d.buf. Move it back to the
+			// start so the next filter can also use the spare capacity.
+			b = d.buf[:copy(d.buf, b)]
+		}
 	}
 }
 
 // bytes returns a decoded byte slice or an error.
 func (d *decodeReader) bytes() ([]byte, error) {
+	// return any output left over from a previous Read
+	if len(d.outbuf) > 0 {
+		b := d.outbuf
+		d.outbuf = nil
+		return b, nil
+	}
 	// fill window if needed
 	if d.w == d.r {
 		if err := d.fill(); err != nil {
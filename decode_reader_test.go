@@ -0,0 +1,103 @@
+package rardecode
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// copyBytesRef is copyBytes done a byte at a time, stopping when the window
+// is full. It returns the new write index and the length left to copy after
+// the window wraps. Lengths are less than the window size, as windows are at
+// least minWindowSize bytes.
+func copyBytesRef(win []byte, w, length, offset int) (int, int) {
+	for ; length > 0 && w < len(win); length-- {
+		win[w] = win[((w-offset)%len(win)+len(win))%len(win)]
+		w++
+	}
+	return w, length
+}
+
+func testCopyBytes(t *testing.T, name string, size, w, length, offset int) {
+	t.Helper()
+	win := make([]byte, size)
+	for i := range win {
+		win[i] = byte(i*7 + i/251)
+	}
+	want := append([]byte(nil), win...)
+	wantW, wantRem := copyBytesRef(want, w, length, offset)
+	d := &decodeReader{win: win, size: size, w: w}
+	d.copyBytes(length, offset)
+	if d.w != wantW || d.rem != wantRem || !bytes.Equal(d.win, want) {
+		t.Errorf("%s: size %d, w %d, length %d, offset %d: got w %d, rem %d, want %d, %d, windows equal %v",
+			name, size, w, length, offset, d.w, d.rem, wantW, wantRem, bytes.Equal(d.win, want))
+	}
+}
+
+func TestCopyBytes(t *testing.T) {
+	const size = 0x1000
+	tests := []struct {
+		name              string
+		w, length, offset int
+	}{
+		{"no overlap", 100, 10, 50},
+		{"overlap", 100, 50, 10},
+		{"run of one byte", 100, 300, 1},
+		{"run of two bytes", 100, 301, 2},
+		{"source at window start", 100, 20, 100},
+		{"source wraps to window end", 10, 30, 20},
+		{"source at window end", 10, 5, 11},
+		{"source wraps and overlaps", 10, 40, 12},
+		{"offset is window size", 100, 10, size},
+		{"offset one less than window size", 100, 10, size - 1},
+		{"destination ends at window end", size - 10, 10, 5},
+		{"destination past window end", size - 10, 20, 5},
+		{"destination past window end with wrapped source", size - 10, 20, size - 5},
+		{"write index at zero", 0, 10, 5},
+		{"full window", size, 10, 5},
+		{"zero length", 100, 0, 5},
+	}
+	for _, test := range tests {
+		testCopyBytes(t, test.name, size, test.w, test.length, test.offset)
+	}
+}
+
+func TestCopyBytesExhaustive(t *testing.T) {
+	// RAR 7 window sizes aren't always a power of 2
+	for _, size := range []int{16, 13} {
+		for w := 0; w <= size; w++ {
+			for offset := 1; offset <= size; offset++ {
+				for length := 0; length < size; length++ {
+					testCopyBytes(t, "exhaustive", size, w, length, offset)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeAcrossWindowEnd(t *testing.T) {
+	// data longer than the smallest window, so matches cross its end
+	data := testData(3*minWindowSize+1000, 4)
+	for _, test := range []struct {
+		name string
+		arc  []byte
+	}{
+		{"rar29", testArchive15(compressedFile15("f", data))},
+		{"rar50", testArchive50(0, compressedFile50("f", data, false))},
+	} {
+		r, err := NewReader(bytes.NewReader(test.arc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = r.Next(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Fatalf("%s: data mismatch", test.name)
+		}
+	}
+}
@@ -57,14 +57,44 @@ func (c *cipherBlockSliceReader) readSlice(n int) ([]byte, error) {
 	return b, nil
 }
 
+// Decrypter creates the cipher.BlockMode used to decrypt encrypted data and
+// block headers, replacing the crypto/aes implementation when set by the
+// UseDecrypter option. RAR archives are encrypted using AES in CBC mode, with
+// a 128 bit key for RAR 1.5 format archives and a 256 bit key for RAR 5.
+type Decrypter interface {
+	NewDecrypter(key, iv []byte) (cipher.BlockMode, error)
+}
+
+// UseDecrypter sets the Decrypter used to decrypt archives, such as one using
+// a hardware security module or a certified cryptographic module.
+func UseDecrypter(d Decrypter) Option {
+	return func(o *option) { o.dec = d }
+}
+
+// newAesMode returns a cipher.BlockMode that decrypts AES in CBC mode using key
+// and iv. It is created by d if set, otherwise block is used if it is a cached
+// AES cipher for key.
+func newAesMode(d Decrypter, key, iv []byte, block cipher.Block) (cipher.BlockMode, error) {
+	if d != nil {
+		return d.NewDecrypter(key, iv)
+	}
+	if block == nil {
+		var err error
+		block, err = aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cipher.NewCBCDecrypter(block, iv), nil
+}
+
 // newAesSliceReader creates a sliceReader that uses AES to decrypt the input
-func newAesSliceReader(r sliceReader, key, iv []byte) *cipherBlockSliceReader {
-	block, err := aes.NewCipher(key)
+func newAesSliceReader(r sliceReader, d Decrypter, key, iv []byte, block cipher.Block) (*cipherBlockSliceReader, error) {
+	mode, err := newAesMode(d, key, iv, block)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	mode := cipher.NewCBCDecrypter(block, iv)
-	return &cipherBlockSliceReader{r: r, mode: mode}
+	return &cipherBlockSliceReader{r: r, mode: mode}, nil
 }
 
 // cipherBlockReader implements Block Mode decryption of an io.Reader object.
@@ -187,17 +217,13 @@ func newCipherBlockReader(r byteReader, getMode func() (cipher.BlockMode, error)
 }
 
 // newAesDecryptReader returns a cipherBlockReader that decrypts input from a given io.Reader using AES.
-func newAesDecryptReader(r byteReader, h *fileBlockHeader) *cipherBlockReader {
+func newAesDecryptReader(r byteReader, h *fileBlockHeader, d Decrypter) *cipherBlockReader {
 	getMode := func() (cipher.BlockMode, error) {
 		err := h.genKeys()
 		if err != nil {
 			return nil, err
 		}
-		block, err := aes.NewCipher(h.key)
-		if err != nil {
-			return nil, err
-		}
-		return cipher.NewCBCDecrypter(block, h.iv), nil
+		return newAesMode(d, h.key, h.iv, h.block)
 	}
 	return newCipherBlockReader(r, getMode)
 }
@@ -0,0 +1,81 @@
+package rardecode
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"testing"
+)
+
+// chunkReader is a byteReader returning b in slices of up to n bytes.
+type chunkReader struct {
+	b []byte
+	n int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.b) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.b[:min(c.n, len(c.b))])
+	c.b = c.b[n:]
+	return n, nil
+}
+
+func (c *chunkReader) bytes() ([]byte, error) {
+	if len(c.b) == 0 {
+		return nil, io.EOF
+	}
+	n := min(c.n, len(c.b))
+	b := c.b[:n]
+	c.b = c.b[n:]
+	return b, nil
+}
+
+func BenchmarkAESDecrypt(b *testing.B) {
+	key := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := make([]byte, 1<<20)
+	getMode := func() (cipher.BlockMode, error) { return cipher.NewCBCDecrypter(block, iv), nil }
+	// bytes decrypts in place, as done for the decoder, and Read decrypts
+	// into the caller's buffer, as done for stored files
+	b.Run("bytes", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			cr := newCipherBlockReader(newBufByteReader(data), getMode)
+			for {
+				if _, err := cr.bytes(); err != nil {
+					break
+				}
+			}
+		}
+	})
+	// blocks spanning the slices returned by the reader are decrypted alone
+	b.Run("bytes/unaligned", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			cr := newCipherBlockReader(&chunkReader{b: data, n: 4000}, getMode)
+			for {
+				if _, err := cr.bytes(); err != nil {
+					break
+				}
+			}
+		}
+	})
+	b.Run("Read", func(b *testing.B) {
+		buf := make([]byte, 32<<10)
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			cr := newCipherBlockReader(newBufByteReader(data), getMode)
+			for {
+				if _, err := cr.Read(buf); err != nil {
+					break
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package rardecode
+
+import "os"
+
+// DiskWindow makes files needing a larger dictionary than MaxDictionarySize
+// use a decode window stored in a temporary file in dir, instead of returning
+// ErrDictionaryTooLarge. The file is mapped into memory, so the operating
+// system's page cache holds the parts of the window in use and the rest is
+// written to disk. An empty dir uses the default directory for temporary files.
+// The file is removed when a file that isn't solid is decoded with a smaller
+// dictionary, the end of the archive is reached or the ReadCloser is closed.
+// Disk windows are unavailable on platforms without memory mapping.
+func DiskWindow(dir string) Option {
+	return func(o *option) { o.winDir = &dir }
+}
+
+// diskWindow is a decode window stored in a temporary file mapped into memory.
+type diskWindow struct {
+	b []byte   // mapped window
+	f *os.File // temporary file backing b
+}
+
+func newDiskWindow(dir string, size int) (*diskWindow, error) {
+	f, err := os.CreateTemp(dir, "rardecode-*.win")
+	if err != nil {
+		return nil, err
+	}
+	// the file is sparse, so the window starts zeroed without writing to disk
+	err = f.Truncate(int64(size))
+	if err == nil {
+		var b []byte
+		b, err = mmap(f, size, true)
+		if err == nil {
+			return &diskWindow{b: b, f: f}, nil
+		}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil, err
+}
+
+// close unmaps the window and removes its file.
+func (w *diskWindow) close() error {
+	err := munmap(w.b)
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	if rerr := os.Remove(w.f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,9 @@
+// Package rardecode reads RAR archives, in both the RAR 1.5 to 4.x format and
+// the RAR 5 format also used by RAR 7.
+//
+// Errors returned after an archive has been opened, such as by Reader.Next,
+// Read and List, are of type *Error, which records where in the archive the
+// error occurred. The sentinel errors of this package, such as ErrBadPassword,
+// are wrapped in it, so they must be tested for with errors.Is rather than
+// compared with ==. io.EOF is always returned unwrapped.
+package rardecode
@@ -0,0 +1,68 @@
+package rardecode
+
+import "errors"
+
+// DuplicatePolicy values
+const (
+	DuplicateKeepAll            = 0 // all files with the same name are kept
+	DuplicateKeepFirst          = 1 // the first file with a name is kept
+	DuplicateKeepLast           = 2 // the last file with a name is kept
+	DuplicateKeepHighestVersion = 3 // the file with the highest Version is kept, the last if equal
+	DuplicateError              = 4 // ErrDuplicateName is returned
+)
+
+var ErrDuplicateName = errors.New("rardecode: duplicate file name")
+
+// DuplicatePolicy sets how List, OpenReaderAt, ListHeaders and Extract handle
+// an archive containing more than one file with the same name, which can mean
+// older versions of a file were kept or that a file is being hidden by a later
+// one. The default is DuplicateKeepAll. Files that are kept stay in archive
+// order.
+func DuplicatePolicy(policy int) Option {
+	return func(o *option) { o.dup = policy }
+}
+
+// keepFiles returns which of the n files with headers h(i) are kept by policy,
+// or nil if all of them are.
+func keepFiles(policy, n int, h func(i int) *FileHeader) ([]bool, error) {
+	if policy == DuplicateKeepAll {
+		return nil, nil
+	}
+	keep := make([]bool, n)
+	kept := make(map[string]int, n) // index of the kept file by name
+	for i := 0; i < n; i++ {
+		fh := h(i)
+		j, ok := kept[fh.Name]
+		switch {
+		case !ok:
+		case policy == DuplicateError:
+			return nil, ErrDuplicateName
+		case policy == DuplicateKeepFirst:
+			continue
+		case policy == DuplicateKeepHighestVersion && fh.Version < h(j).Version:
+			continue
+		default:
+			keep[j] = false
+		}
+		keep[i] = true
+		kept[fh.Name] = i
+	}
+	return keep, nil
+}
+
+// removeFiles removes the files in fl that aren't kept, keeping the order of
+// the others. All files are kept if keep is nil.
+func removeFiles[T any](fl []T, keep []bool) []T {
+	if keep == nil {
+		return fl
+	}
+	n := 0
+	for i, f := range fl {
+		if keep[i] {
+			fl[n] = f
+			n++
+		}
+	}
+	clear(fl[n:])
+	return fl[:n]
+}
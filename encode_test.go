@@ -0,0 +1,456 @@
+package rardecode
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"testing/fstest"
+
+	"github.com/nwaples/rardecode/v2/internal/rartest"
+)
+
+// Encoders producing compressed data for tests. They use fixed code lengths
+// and simple greedy matching, so the output is valid but not small.
+
+// bitWriter writes bits most significant first, as read by the bit readers.
+type bitWriter struct {
+	b []byte
+	n uint8 // bits used in the last byte of b, 0 if it is full
+}
+
+func (w *bitWriter) write(v int, n uint8) {
+	for i := int(n) - 1; i >= 0; i-- {
+		if w.n == 0 {
+			w.b = append(w.b, 0)
+		}
+		if v>>i&1 > 0 {
+			w.b[len(w.b)-1] |= 0x80 >> w.n
+		}
+		w.n = (w.n + 1) & 7
+	}
+}
+
+// bits returns the number of bits written.
+func (w *bitWriter) bits() int {
+	if w.n == 0 {
+		return len(w.b) * 8
+	}
+	return (len(w.b)-1)*8 + int(w.n)
+}
+
+// huffmanCode is a canonical huffman code, as decoded by huffmanDecoder.
+type huffmanCode struct {
+	code []int
+	len  []byte
+}
+
+func newHuffmanCode(lengths []byte) *huffmanCode {
+	h := &huffmanCode{code: make([]int, len(lengths)), len: lengths}
+	code := 0
+	for l := byte(1); l <= maxCodeLength; l++ {
+		for i, n := range lengths {
+			if n == l {
+				h.code[i] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return h
+}
+
+func (h *huffmanCode) write(w *bitWriter, sym int) {
+	if h.len[sym] == 0 {
+		panic("symbol has no code")
+	}
+	w.write(h.code[sym], h.len[sym])
+}
+
+// fixedLengths returns n code lengths of l bits.
+func fixedLengths(n int, l byte) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = l
+	}
+	return b
+}
+
+// match is a literal byte if length is 0, otherwise a copy of length bytes
+// from offset bytes before the current position.
+type match struct {
+	lit    byte
+	length int
+	offset int
+}
+
+// findMatches splits data into literals and greedy matches of at least min
+// bytes with offsets up to maxOff.
+func findMatches(data []byte, min, maxLen, maxOff int) []match {
+	var m []match
+	last := make(map[[3]byte]int)
+	for i := 0; i < len(data); {
+		if i+3 <= len(data) {
+			k := [3]byte{data[i], data[i+1], data[i+2]}
+			j, ok := last[k]
+			last[k] = i
+			if ok && i-j <= maxOff {
+				n := 0
+				for i+n < len(data) && n < maxLen && data[j+n] == data[i+n] {
+					n++
+				}
+				if n >= min {
+					m = append(m, match{length: n, offset: i - j})
+					i += n
+					continue
+				}
+			}
+		}
+		m = append(m, match{lit: data[i]})
+		i++
+	}
+	return m
+}
+
+// slot returns the slot for v, the highest slot whose base from baseFn is
+// at most v, and the extra bits of v following the base.
+func slot(v, slots int, baseFn func(s int) (base int, bits uint8)) (int, int, uint8) {
+	for s := slots - 1; s >= 0; s-- {
+		if base, bits := baseFn(s); base <= v {
+			return s, v - base, bits
+		}
+	}
+	panic("value has no slot")
+}
+
+func lengthSlot50(s int) (int, uint8) {
+	if s < 8 {
+		return s, 0
+	}
+	bits := uint8(s/4 - 1)
+	return (4 | (s & 3)) << bits, bits
+}
+
+func offsetSlot50(s int) (int, uint8) {
+	if s < 4 {
+		return s, 0
+	}
+	bits := uint8(s/2 - 1)
+	return (2 | (s & 1)) << bits, bits
+}
+
+// compress50 returns data compressed in the RAR 5 format, in blocks of up to
+// blockSize matches. Each block stores new code length tables.
+func compress50(data []byte, blockSize int) []byte {
+	lengths := append(fixedLengths(mainSize5, 9), fixedLengths(offsetSize5, 6)...)
+	lengths = append(lengths, fixedLengths(lowoffsetSize5, 4)...)
+	lengths = append(lengths, fixedLengths(lengthSize5, 6)...)
+	mainCode := newHuffmanCode(lengths[:mainSize5])
+	offsetCode := newHuffmanCode(lengths[mainSize5 : mainSize5+offsetSize5])
+	lowCode := newHuffmanCode(lengths[mainSize5+offsetSize5 : mainSize5+offsetSize5+lowoffsetSize5])
+	// only code lengths 4, 6 and 9 are used, given 2 bit codes
+	var blen [20]byte
+	blen[4], blen[6], blen[9] = 2, 2, 2
+	blCode := newHuffmanCode(blen[:])
+
+	var out []byte
+	// offsets fit the smallest dictionary, and keep encoded lengths at least 2
+	m := findMatches(data, 4, 0x1000, 0x20000)
+	for len(m) > 0 || out == nil {
+		n := min(blockSize, len(m))
+		w := new(bitWriter)
+		for _, l := range blen {
+			w.write(int(l), 4)
+		}
+		for _, l := range lengths {
+			blCode.write(w, int(l))
+		}
+		for _, v := range m[:n] {
+			if v.length == 0 {
+				mainCode.write(w, int(v.lit))
+				continue
+			}
+			length := v.length
+			if v.offset > 0x100 {
+				length--
+				if v.offset > 0x2000 {
+					length--
+					if v.offset > 0x40000 {
+						length--
+					}
+				}
+			}
+			ls, lx, lbits := slot(length-2, lengthSize5, lengthSlot50)
+			mainCode.write(w, 262+ls)
+			w.write(lx, lbits)
+			os, ox, obits := slot(v.offset-1, offsetSize5, offsetSlot50)
+			offsetCode.write(w, os)
+			if obits >= 4 {
+				w.write(ox>>4, obits-4)
+				lowCode.write(w, ox&0xf)
+			} else {
+				w.write(ox, obits)
+			}
+		}
+		m = m[n:]
+		flags := byte(0x80 | (w.bits() - (len(w.b)-1)*8 - 1))
+		if len(m) == 0 {
+			flags |= 0x40
+		}
+		size := len(w.b)
+		var sb []byte
+		for ; size > 0 || len(sb) == 0; size >>= 8 {
+			sb = append(sb, byte(size))
+		}
+		flags |= byte(len(sb)-1) << 3
+		sum := 0x5a ^ flags
+		for _, c := range sb {
+			sum ^= c
+		}
+		out = append(out, flags, sum)
+		out = append(out, sb...)
+		out = append(out, w.b...)
+	}
+	return out
+}
+
+func lengthSlot29(s int) (int, uint8) { return lengthBase[s], lengthExtraBits[s] }
+
+func offsetSlot29(s int) (int, uint8) { return offsetBase[s], offsetExtraBits[s] }
+
+// compress29 returns data compressed in the RAR 2.9 LZ format, in blocks of up
+// to blockSize matches. Each block stores new code length tables.
+func compress29(data []byte, blockSize int) []byte {
+	lengths := append(fixedLengths(mainSize, 9), fixedLengths(offsetSize, 6)...)
+	lengths = append(lengths, fixedLengths(lowOffsetSize, 5)...)
+	lengths = append(lengths, fixedLengths(lengthSize, 5)...)
+	mainCode := newHuffmanCode(lengths[:mainSize])
+	offsetCode := newHuffmanCode(lengths[mainSize : mainSize+offsetSize])
+	lowCode := newHuffmanCode(lengths[mainSize+offsetSize : mainSize+offsetSize+lowOffsetSize])
+	// only code lengths 5, 6 and 9 are used, given 2 bit codes
+	var blen [20]byte
+	blen[5], blen[6], blen[9] = 2, 2, 2
+	blCode := newHuffmanCode(blen[:])
+
+	w := new(bitWriter)
+	// offsets fit the smallest window, so lengths are adjusted by at most 1
+	m := findMatches(data, 4, 0xff, minWindowSize-1)
+	for {
+		w.write(0, 2) // lz block, new tables
+		for _, l := range blen {
+			w.write(int(l), 4)
+		}
+		for _, l := range lengths {
+			blCode.write(w, int(l))
+		}
+		n := min(blockSize, len(m))
+		for _, v := range m[:n] {
+			if v.length == 0 {
+				mainCode.write(w, int(v.lit))
+				continue
+			}
+			length := v.length
+			if v.offset >= 0x2000 {
+				length--
+			}
+			ls, lx, lbits := slot(length-3, lengthSize, lengthSlot29)
+			mainCode.write(w, 271+ls)
+			w.write(lx, lbits)
+			os, ox, obits := slot(v.offset-1, offsetSize, offsetSlot29)
+			offsetCode.write(w, os)
+			if obits >= 4 {
+				w.write(ox>>4, obits-4)
+				lowCode.write(w, ox&0xf)
+			} else {
+				w.write(ox, obits)
+			}
+		}
+		m = m[n:]
+		mainCode.write(w, 256)
+		if len(m) == 0 {
+			w.write(0, 2) // end of file
+			return w.b
+		}
+		w.write(1, 1)         // end of block
+		w.write(0, (8-w.n)%8) // align to a byte
+	}
+}
+
+// encoder20 compresses data in the RAR 2.0 format, in LZ or audio blocks.
+// Blocks can continue across files, as they do in a solid archive.
+type encoder20 struct {
+	w     *bitWriter
+	audio audio20Decoder // predicts audio bytes as the decoder does
+	code  *huffmanCode   // code for each audio channel, nil in an LZ block
+	main  *huffmanCode   // LZ main code
+	off   *huffmanCode   // LZ offset code
+}
+
+// writeCodeLengthTable20 writes a RAR 2.0 code length table. Only code
+// lengths 5, 6 and 9 are used, given 2 bit codes.
+func writeCodeLengthTable20(w *bitWriter, lengths []byte) {
+	var blen [19]byte
+	blen[5], blen[6], blen[9] = 2, 2, 2
+	blCode := newHuffmanCode(blen[:])
+	for _, l := range blen {
+		w.write(int(l), 4)
+	}
+	for _, l := range lengths {
+		blCode.write(w, int(l))
+	}
+}
+
+// lzBlock starts an LZ block with new tables.
+func (e *encoder20) lzBlock() {
+	if e.w == nil {
+		e.w = new(bitWriter)
+	}
+	e.w.write(0, 2) // lz block, new tables
+	lengths := append(fixedLengths(main20Size, 9), fixedLengths(offset20Size, 6)...)
+	lengths = append(lengths, fixedLengths(length20Size, 5)...)
+	writeCodeLengthTable20(e.w, lengths)
+	e.main = newHuffmanCode(lengths[:main20Size])
+	e.off = newHuffmanCode(lengths[main20Size : main20Size+offset20Size])
+	e.code = nil
+}
+
+// audioBlock starts an audio block of chans channels with new tables.
+func (e *encoder20) audioBlock(chans int) {
+	if e.w == nil {
+		e.w = new(bitWriter)
+	}
+	e.w.write(2, 2) // audio block, new tables
+	e.w.write(chans-1, 2)
+	var lengths []byte
+	for i := 0; i < chans; i++ {
+		lengths = append(lengths, fixedLengths(audioSize, 9)...)
+	}
+	writeCodeLengthTable20(e.w, lengths)
+	e.code = newHuffmanCode(lengths[:audioSize])
+	e.audio.chans = chans
+	if e.audio.curChan >= chans {
+		e.audio.curChan = 0
+	}
+}
+
+// write compresses data in the current block.
+func (e *encoder20) write(data []byte) {
+	if e.code == nil {
+		// offsets are below 0x2000, so lengths aren't adjusted
+		for _, v := range findMatches(data, 3, 0xff, 0x2000-1) {
+			if v.length == 0 {
+				e.main.write(e.w, int(v.lit))
+				continue
+			}
+			ls, lx, lbits := slot(v.length-3, length20Size, lengthSlot29)
+			e.main.write(e.w, 270+ls)
+			e.w.write(lx, lbits)
+			os, ox, obits := slot(v.offset-1, offset20Size, offsetSlot29)
+			e.off.write(e.w, os)
+			e.w.write(ox, obits)
+		}
+		return
+	}
+	a := &e.audio
+	for _, c := range data {
+		// decoding a zero delta returns the predicted byte
+		v, chanDelta := a.vars[a.curChan], a.chanDelta
+		pch := a.decode(0)
+		a.vars[a.curChan], a.chanDelta = v, chanDelta
+		delta := int(pch - c)
+		e.code.write(e.w, delta)
+		a.decode(delta)
+		a.curChan = (a.curChan + 1) % a.chans
+	}
+}
+
+// endBlock writes the end of block symbol.
+func (e *encoder20) endBlock() {
+	if e.code == nil {
+		e.main.write(e.w, 269)
+	} else {
+		e.code.write(e.w, 256)
+	}
+}
+
+// bytes returns the data written since the last call, for the packed data
+// of a file.
+func (e *encoder20) bytes() []byte {
+	b := e.w.b
+	e.w = new(bitWriter)
+	return b
+}
+
+// compressedFile50 returns a RAR 5 file block for data compressed with
+// compress50, for a solid archive if solid is set.
+func compressedFile50(name string, data []byte, solid bool) []byte {
+	f := rartest.NewFile50(name, data)
+	f.Data = compress50(data, 1000)
+	f.Compression = 3 << 7 // method 3, 128KB dictionary
+	if solid {
+		f.Compression |= 0x40
+	}
+	return f.Bytes()
+}
+
+// testArchive50 returns a RAR 5 archive with main archive flags and blocks.
+func testArchive50(flags uint64, blocks ...[]byte) []byte {
+	blocks = append([][]byte{rartest.Main50(flags)}, blocks...)
+	return rartest.Archive(rartest.Sig50, append(blocks, rartest.End50(0))...)
+}
+
+// splitArchive50 returns a file system with a RAR 5 archive of volumes named
+// "v.partN.rar", containing a stored file split into the given parts.
+func splitArchive50(name string, parts ...[]byte) fstest.MapFS {
+	var data []byte
+	for _, p := range parts {
+		data = append(data, p...)
+	}
+	fsys := make(fstest.MapFS)
+	for i, p := range parts {
+		f := rartest.NewFile50(name, data)
+		f.Data = p
+		var end uint64
+		if i > 0 {
+			f.BlockFlags |= 0x0008 // split before
+		}
+		if i < len(parts)-1 {
+			f.BlockFlags |= 0x0010 // split after
+			end = 1                // not last volume
+		}
+		b := rartest.Archive(rartest.Sig50, rartest.Main50(0x1), f.Bytes(), rartest.End50(end))
+		fsys[fmt.Sprintf("v.part%d.rar", i+1)] = &fstest.MapFile{Data: b}
+	}
+	return fsys
+}
+
+// compressedFile15 returns a RAR 1.5 file block for data compressed with
+// compress29.
+func compressedFile15(name string, data []byte) []byte {
+	f := rartest.NewFile15(name, data)
+	f.Data = compress29(data, 1000)
+	f.Method = 0x33
+	return f.Bytes()
+}
+
+// encryptedFile50 returns a RAR 5 stored file block for data encrypted with
+// pass, including a password check value.
+func encryptedFile50(name string, data []byte, pass string) []byte {
+	const kdfCount = 4
+	salt := []byte("0123456789abcdef")
+	iv := []byte("fedcba9876543210")
+	keys := calcKeys50([]byte(pass), salt, 1<<kdfCount)
+	block, err := aes.NewCipher(keys[0])
+	if err != nil {
+		panic(err)
+	}
+	b := make([]byte, (len(data)+aes.BlockSize-1)/aes.BlockSize*aes.BlockSize)
+	copy(b, data)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(b, b)
+	rec := append(rartest.Uvarint(0), rartest.Uvarint(0x1)...) // version, check present
+	rec = append(rec, kdfCount)
+	rec = append(append(append(rec, salt...), iv...), keys[2]...)
+	f := rartest.NewFile50(name, data)
+	f.Extra = rartest.Record50(1, rec)
+	f.Data = b
+	return f.Bytes()
+}
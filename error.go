@@ -0,0 +1,75 @@
+package rardecode
+
+import (
+	"fmt"
+	"io"
+)
+
+// Error records an error and the location in the archive where it occurred.
+// Errors returned after an archive has been opened are of type *Error,
+// except for io.EOF. Use errors.Is to test for the underlying error.
+type Error struct {
+	Volume     int    // volume number, starting at 0
+	VolumeName string // volume path (empty if the archive was not opened by name)
+	Offset     int64  // offset of the block header in the volume
+	BlockType  string // type of block (eg. "file" or "service"), empty if the header couldn't be read
+	File       string // name of the file being read, if any
+	Err        error  // underlying error
+}
+
+func (e *Error) Error() string {
+	s := e.Err.Error() + " ("
+	if e.VolumeName != "" {
+		s += fmt.Sprintf("volume %q", e.VolumeName)
+	} else {
+		s += fmt.Sprintf("volume %d", e.Volume)
+	}
+	s += fmt.Sprintf(", offset %d", e.Offset)
+	if e.BlockType != "" {
+		s += ", " + e.BlockType + " block"
+	}
+	if e.File != "" {
+		s += fmt.Sprintf(", file %q", e.File)
+	}
+	return s + ")"
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// UnsafeNameError is returned for a file name that isn't safe to extract,
+// either by SanitizeName or when the RejectUnsafeNames option is used.
+type UnsafeNameError struct {
+	Name   string // file name as stored in the archive
+	Reason string // why the name is unsafe
+}
+
+func (e *UnsafeNameError) Error() string {
+	return fmt.Sprintf("rardecode: unsafe file name %q: %s", e.Name, e.Reason)
+}
+
+// A DamagedRegion is a range of data in a volume that was skipped because
+// of the SkipDamaged option.
+type DamagedRegion struct {
+	Volume     int    // volume number, starting at 0
+	VolumeName string // volume path (empty if the archive was not opened by name)
+	Offset     int64  // offset of the first skipped byte
+	Size       int64  // number of bytes skipped
+	Err        error  // error that caused the data to be skipped
+}
+
+// wrapErr returns err as an *Error recording the current location in v.
+// file is the name of the file being read, if any.
+// io.EOF and errors that are already an *Error are returned unchanged.
+func (v *volume) wrapErr(err error, file string) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	e := &Error{Volume: v.num, Offset: v.boff, BlockType: v.blk, File: file, Err: err}
+	if v.file != "" {
+		e.VolumeName = v.dir + v.file
+	}
+	return e
+}
@@ -0,0 +1,50 @@
+package rardecode_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"testing/fstest"
+
+	"github.com/nwaples/rardecode/v2"
+)
+
+func ExampleFileSystem() {
+	// a file stored in a RAR 5 archive of two volumes
+	fsys := fstest.MapFS{
+		"archive.part1.rar": {Data: []byte{
+			0x52, 0x61, 0x72, 0x21, 0x1a, 0x07, 0x01, 0x00, 0x53, 0x2a, 0x34, 0x45,
+			0x03, 0x01, 0x00, 0x01, 0xc0, 0x53, 0xfb, 0x60, 0x17, 0x02, 0x12, 0x07,
+			0x04, 0x0e, 0xa4, 0x03, 0x18, 0xa7, 0x55, 0x7b, 0x00, 0x01, 0x09, 0x68,
+			0x65, 0x6c, 0x6c, 0x6f, 0x2e, 0x74, 0x78, 0x74, 0x48, 0x65, 0x6c, 0x6c,
+			0x6f, 0x2c, 0x20, 0x8f, 0x82, 0x3d, 0x42, 0x03, 0x05, 0x00, 0x01,
+		}},
+		"archive.part2.rar": {Data: []byte{
+			0x52, 0x61, 0x72, 0x21, 0x1a, 0x07, 0x01, 0x00, 0x53, 0x2a, 0x34, 0x45,
+			0x03, 0x01, 0x00, 0x01, 0x81, 0x3f, 0x4f, 0x70, 0x17, 0x02, 0x0a, 0x07,
+			0x04, 0x0e, 0xa4, 0x03, 0x18, 0xa7, 0x55, 0x7b, 0x00, 0x01, 0x09, 0x68,
+			0x65, 0x6c, 0x6c, 0x6f, 0x2e, 0x74, 0x78, 0x74, 0x77, 0x6f, 0x72, 0x6c,
+			0x64, 0x21, 0x0a, 0x19, 0xb2, 0x3a, 0x35, 0x03, 0x05, 0x00, 0x00,
+		}},
+	}
+	rc, err := rardecode.OpenReader("archive.part1.rar", rardecode.FileSystem(fsys))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rc.Close()
+	for {
+		h, err := rc.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s: %q from %d volumes\n", h.Name, b, len(h.VolumeSpans))
+	}
+	// Output:
+	// hello.txt: "Hello, world!\n" from 2 volumes
+}
@@ -0,0 +1,113 @@
+package rardecode
+
+import (
+	"io"
+	"sync"
+)
+
+// ExtractFunc is the type of function called by Extract for each file in an archive.
+// The reader r is only valid until the function returns.
+type ExtractFunc func(h *FileHeader, r io.Reader) error
+
+// Extract calls fn for each file in the RAR archive specified by name.
+// If the Parallel option is used with a non-solid archive, files are decoded
+// concurrently and fn may be called from multiple goroutines at once.
+// Extract stops at the first error returned by fn or encountered while reading
+// the archive, and returns that error. Files not kept by the DuplicatePolicy
+// option are skipped without calling fn.
+func Extract(name string, fn ExtractFunc, opts ...Option) error {
+	var o option
+	for _, f := range opts {
+		f(&o)
+	}
+	var keep []bool // files to extract by index, or nil for all files
+	if (o.parallel > 1 && o.win == nil) || o.dup != DuplicateKeepAll {
+		files, err := List(name, append(opts[:len(opts):len(opts)], DuplicatePolicy(DuplicateKeepAll))...)
+		if err != nil {
+			return err
+		}
+		keep, err = keepFiles(o.dup, len(files), func(i int) *FileHeader { return &files[i].FileHeader })
+		if err != nil {
+			return err
+		}
+		if o.parallel > 1 && o.win == nil && (len(files) == 0 || !files[0].pr.h.arcSolid) {
+			return extractParallel(removeFiles(files, keep), fn, o.parallel)
+		}
+	}
+	r, err := OpenReader(name, opts...)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for i := 0; ; i++ {
+		h, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if keep != nil && (i >= len(keep) || !keep[i]) {
+			continue
+		}
+		if err = fn(h, r); err != nil {
+			return err
+		}
+	}
+}
+
+// extractParallel calls fn for each file using n worker goroutines.
+// Each worker reuses a single decodeReader for all the files it decodes.
+func extractParallel(files []*File, fn ExtractFunc, n int) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		ferr error // first error encountered
+	)
+	setErr := func(err error) {
+		mu.Lock()
+		if ferr == nil {
+			ferr = err
+		}
+		mu.Unlock()
+	}
+	getErr := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return ferr
+	}
+
+	work := make(chan *File)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var dr *decodeReader
+			for f := range work {
+				r, err := f.open(dr)
+				if err == nil {
+					err = fn(&f.FileHeader, r)
+					dr = r.dr
+					if cerr := r.closeVolume(); err == nil {
+						err = cerr
+					}
+				}
+				if err != nil {
+					setErr(err)
+				}
+			}
+			if dr != nil {
+				dr.release()
+			}
+		}()
+	}
+	for _, f := range files {
+		if getErr() != nil {
+			break
+		}
+		work <- f
+	}
+	close(work)
+	wg.Wait()
+	return ferr
+}
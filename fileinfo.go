@@ -0,0 +1,40 @@
+package rardecode
+
+import (
+	"io/fs"
+	"path"
+	"time"
+)
+
+// FileSys is the raw host information stored for a file, returned by the Sys
+// method of the fs.FileInfo from FileHeader.FileInfo. It allows OS specific
+// attribute bits, such as the Windows hidden and archive bits, to be checked.
+type FileSys struct {
+	HostOS         byte   // host OS the archive was created on
+	Attributes     int64  // host OS specific file attributes
+	Flags          uint64 // RAR 5 file flags, or the block header flags of a RAR 1.5 file
+	BlockFlags     uint64 // flags common to all RAR 5 block headers, 0 for RAR 1.5 files
+	Format         int    // archive format version, FormatRAR15 or FormatRAR50
+	ExtractVersion int    // RAR 1.5 version needed to extract, or RAR 5 compression algorithm version
+}
+
+// FileInfo returns an fs.FileInfo describing the file. Its Sys method returns
+// a *FileSys.
+func (f *FileHeader) FileInfo() fs.FileInfo { return fileInfo{f} }
+
+// fileInfo implements fs.FileInfo for a FileHeader.
+type fileInfo struct {
+	h *FileHeader
+}
+
+func (fi fileInfo) Name() string       { return path.Base(fi.h.Name) }
+func (fi fileInfo) Size() int64        { return max(fi.h.UnPackedSize, 0) }
+func (fi fileInfo) Mode() fs.FileMode  { return fi.h.Mode() }
+func (fi fileInfo) ModTime() time.Time { return fi.h.ModificationTime }
+func (fi fileInfo) IsDir() bool        { return fi.h.IsDir }
+
+func (fi fileInfo) Sys() any {
+	s := fi.h.raw
+	s.HostOS, s.Attributes = fi.h.HostOS, fi.h.Attributes
+	return &s
+}
@@ -20,20 +20,32 @@ const (
 // the initial register values r, and global data as input for the RAR V3 VM.
 type v3Filter func(r map[int]uint32, global, buf []byte, offset int64) ([]byte, error)
 
+// FilterInfo describes a filter applied to a range of a file's decoded data.
+type FilterInfo struct {
+	Name   string // filter name: "delta", "e8", "e8e9", "arm", "itanium", "rgb", "audio" or "vm"
+	Offset int64  // offset in the file of the first byte processed by the filter
+	Length int    // number of bytes processed by the filter
+}
+
+// FilterFunc is the type of function called by ReportFilters for each filter
+// applied while reading fh.
+type FilterFunc func(fh *FileHeader, f *FilterInfo)
+
 var (
 	// standardV3Filters is a list of known filters. We can replace the use of a vm
 	// filter with a custom filter function.
 	standardV3Filters = []struct {
-		crc uint32   // crc of code byte slice for filter
-		len int      // length of code byte slice for filter
-		f   v3Filter // replacement filter function
+		crc  uint32   // crc of code byte slice for filter
+		len  int      // length of code byte slice for filter
+		f    v3Filter // replacement filter function
+		name string   // name reported in FilterInfo
 	}{
-		{0xad576887, 53, e8FilterV3},
-		{0x3cd7e57e, 57, e8e9FilterV3},
-		{0x3769893f, 120, itaniumFilterV3},
-		{0x0e06077d, 29, deltaFilterV3},
-		{0x1c2c5dc8, 149, filterRGBV3},
-		{0xbc85e701, 216, filterAudioV3},
+		{0xad576887, 53, e8FilterV3, "e8"},
+		{0x3cd7e57e, 57, e8e9FilterV3, "e8e9"},
+		{0x3769893f, 120, itaniumFilterV3, "itanium"},
+		{0x0e06077d, 29, deltaFilterV3, "delta"},
+		{0x1c2c5dc8, 149, filterRGBV3, "rgb"},
+		{0xbc85e701, 216, filterAudioV3, "audio"},
 	}
 
 	// itanium filter byte masks
@@ -126,7 +138,7 @@ func filterDelta(n int, buf []byte) ([]byte, error) {
 	}
 
 	i := 0
-	for j := 0; j < n; j++ {
+	for j := 0; j < n && j < l; j++ {
 		var c byte
 		for k := j; k < len(res); k += n {
 			c -= buf[i]
@@ -209,7 +221,7 @@ func filterAudioV3(r map[int]uint32, global, buf []byte, offset int64) ([]byte,
 	}
 
 	chans := int(r[0])
-	for c := 0; c < chans; c++ {
+	for c := 0; c < chans && c < l; c++ {
 		var prevByte, byteCount int
 		var diff [7]int
 		var d, k [3]int
@@ -290,6 +302,8 @@ type vmFilter struct {
 	global    []byte
 	static    []byte
 	code      []command
+	lim       decodeLimits
+	stats     *Metrics // counts instructions run and bytes output, if set
 }
 
 // execute implements v3filter type for VM based RAR 3 filters.
@@ -330,7 +344,17 @@ func (f *vmFilter) execute(r map[int]uint32, global, buf []byte, offset int64) (
 	}
 	copy(vg[vmFixedGlobalSize+n:], f.static)
 
-	v.execute(f.code)
+	maxCmds := f.lim.vmCmds
+	if maxCmds <= 0 {
+		maxCmds = maxCommands
+	}
+	n, done := v.execute(f.code, maxCmds)
+	if f.stats != nil {
+		f.stats.VMInstructions.Add(int64(n))
+	}
+	if !done && f.lim.vmCmds > 0 {
+		return buf, ErrVMLimitExceeded
+	}
 
 	f.execCount++
 
@@ -363,6 +387,12 @@ func (f *vmFilter) execute(r map[int]uint32, global, buf []byte, offset int64) (
 		start = 0
 		length = 0
 	}
+	if f.lim.vmOut > 0 && int(length) > f.lim.vmOut {
+		return buf, ErrVMLimitExceeded
+	}
+	if f.stats != nil {
+		f.stats.VMOutputBytes.Add(int64(length))
+	}
 	if start != 0 && cap(v.m) > cap(buf) {
 		// Initial buffer was to small for vm.
 		// Copy output to beginning of vm memory so that decodeReader
@@ -374,8 +404,22 @@ func (f *vmFilter) execute(r map[int]uint32, global, buf []byte, offset int64) (
 	return v.m[start : start+length], nil
 }
 
+// v3FilterName returns the name of the standard filter with the code byte slice,
+// or "vm" if it isn't a standard filter.
+func v3FilterName(code []byte) string {
+	c := crc32.ChecksumIEEE(code)
+	for _, f := range standardV3Filters {
+		if f.crc == c && f.len == len(code) {
+			return f.name
+		}
+	}
+	return "vm"
+}
+
 // getV3Filter returns a V3 filter function from a code byte slice.
-func getV3Filter(code []byte) (v3Filter, error) {
+// Filters that aren't standard are run on the vm, restricted by lim, with the
+// resources they use added to stats if it isn't nil.
+func getV3Filter(code []byte, lim decodeLimits, stats *Metrics) (v3Filter, error) {
 	// check if filter is a known standard filter
 	c := crc32.ChecksumIEEE(code)
 	for _, f := range standardV3Filters {
@@ -385,7 +429,10 @@ func getV3Filter(code []byte) (v3Filter, error) {
 	}
 
 	// create new vm filter
-	f := new(vmFilter)
+	if lim.noVM {
+		return nil, ErrVMFilterDisabled
+	}
+	f := &vmFilter{lim: lim, stats: stats}
 	r := newRarBitReader(newBufByteReader(code[1:])) // skip first xor byte check
 
 	// read static data
@@ -0,0 +1,57 @@
+package rardecode
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nwaples/rardecode/v2/internal/rartest"
+)
+
+// fuzzSeeds returns the archives used to seed FuzzReader: the rartest header
+// corpus, archives compressed by the test encoders, and any in testdata.
+func fuzzSeeds(t testing.TB) [][]byte {
+	seeds := rartest.Corpus()
+	data := testData(5000, 0)
+	seeds = append(seeds,
+		testArchive50(0, compressedFile50("c", data, false)),
+		testArchive50(0x4, compressedFile50("a", data[:3000], false), compressedFile50("b", data[3000:], true)),
+		volumes15(2)["v.part1.rar"].Data,
+		volumes50(2)["v.part2.rar"].Data,
+	)
+	names, err := filepath.Glob(filepath.Join("testdata", "*.rar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seeds = append(seeds, b)
+	}
+	return seeds
+}
+
+func FuzzReader(f *testing.F) {
+	for _, b := range fuzzSeeds(f) {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		r, err := NewReader(bytes.NewReader(b), MaxDictionarySize(1<<22), MaxPPMMemory(1<<22))
+		if err != nil {
+			return
+		}
+		for i := 0; i < 100; i++ {
+			if _, err = r.Next(); err != nil {
+				return
+			}
+			// limit the output of files with huge sizes
+			if _, err = io.CopyN(io.Discard, r, 1<<20); err != nil && err != io.EOF {
+				return
+			}
+		}
+	})
+}
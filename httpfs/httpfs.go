@@ -0,0 +1,273 @@
+// Package httpfs implements an fs.FS that reads files from a web server using
+// HTTP range requests. It can be used with the rardecode FileSystem option to
+// read archives, including multi-volume archives, hosted remotely without
+// downloading whole volumes:
+//
+//	fsys, err := httpfs.New(ctx, "https://example.com/files/", nil)
+//	...
+//	rc, err := rardecode.OpenReader("archive.part1.rar", rardecode.FileSystem(fsys))
+//
+// Files are read with a single streamed request until the reader seeks, when
+// a new request is made at the new offset. Short forward seeks read through
+// the current response instead.
+package httpfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxSkip = 0x10000 // largest forward seek done by reading the current response
+
+var ErrNoRange = errors.New("httpfs: server doesn't support range requests")
+
+// FS is an fs.FS for the files below a base URL.
+type FS struct {
+	ctx    context.Context
+	base   *url.URL
+	client *http.Client
+}
+
+// New returns an FS for the files below the URL base. Requests are made with
+// client, or http.DefaultClient if it is nil. Canceling ctx cancels requests
+// for files opened from the FS, including the response being read.
+func New(ctx context.Context, base string, client *http.Client) (*FS, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("httpfs: unsupported URL scheme %q", u.Scheme)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &FS{ctx: ctx, base: u, client: client}, nil
+}
+
+// Open opens the named file. The returned fs.File also implements io.Seeker
+// and io.ReaderAt. If the server doesn't support range requests, the file can
+// only be read sequentially, and reads after seeking backwards or ReadAt
+// return ErrNoRange.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f := &file{fsys: fsys, name: name, url: fsys.base.JoinPath(name).String()}
+	resp, err := f.get(0, -1)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	f.mod, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		f.size, err = rangeSize(resp.Header.Get("Content-Range"))
+	case http.StatusOK:
+		f.size, f.noRange = resp.ContentLength, true
+		if f.size < 0 {
+			err = errors.New("httpfs: unknown file size")
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		f.size = 0 // empty file
+		resp.Body.Close()
+		return f, nil
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	f.body = resp.Body
+	return f, nil
+}
+
+// get requests the bytes of f from off to end inclusive, or to the end of
+// the file if end is negative.
+func (f *file) get(off, end int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(f.fsys.ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	r := "bytes=" + strconv.FormatInt(off, 10) + "-"
+	if end >= 0 {
+		r += strconv.FormatInt(end, 10)
+	}
+	req.Header.Set("Range", r)
+	resp, err := f.fsys.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		return resp, nil
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusGone:
+		return nil, fs.ErrNotExist
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, fs.ErrPermission
+	}
+	return nil, fmt.Errorf("httpfs: %s", resp.Status)
+}
+
+// rangeSize returns the complete length from a Content-Range header.
+func rangeSize(s string) (int64, error) {
+	i := strings.LastIndexByte(s, '/')
+	if !strings.HasPrefix(s, "bytes ") || i < 0 {
+		return 0, fmt.Errorf("httpfs: invalid Content-Range %q", s)
+	}
+	n, err := strconv.ParseInt(s[i+1:], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("httpfs: invalid Content-Range %q", s)
+	}
+	return n, nil
+}
+
+// file is a file opened by FS.
+type file struct {
+	fsys    *FS
+	name    string
+	url     string
+	size    int64
+	mod     time.Time
+	noRange bool          // server ignores range requests
+	off     int64         // offset of the next Read
+	body    io.ReadCloser // response being read, nil if there is none
+	boff    int64         // offset of the next byte in body
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return fileInfo{f}, nil }
+
+// open makes body read from off, reusing the current response if possible.
+func (f *file) open() error {
+	if f.body != nil && f.off >= f.boff && (f.off-f.boff <= maxSkip || f.noRange) {
+		n, err := io.CopyN(io.Discard, f.body, f.off-f.boff)
+		f.boff += n
+		if err == nil {
+			return nil
+		}
+	}
+	if f.noRange {
+		return ErrNoRange
+	}
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	resp, err := f.get(f.off, -1)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return ErrNoRange
+	}
+	f.body, f.boff = resp.Body, f.off
+	return nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.off >= f.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.body == nil || f.boff != f.off {
+		if err := f.open(); err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+	}
+	p = p[:min(int64(len(p)), f.size-f.off)]
+	n, err := f.body.Read(p)
+	f.off += int64(n)
+	f.boff += int64(n)
+	if err == io.EOF {
+		f.body.Close()
+		f.body = nil
+		if f.off < f.size {
+			return n, io.ErrUnexpectedEOF
+		}
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return f.off, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return f.off, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	// the request is made by the next Read
+	f.off = offset
+	return offset, nil
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "readat", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.noRange {
+		return 0, &fs.PathError{Op: "readat", Path: f.name, Err: ErrNoRange}
+	}
+	n := min(int64(len(p)), f.size-off)
+	resp, err := f.get(off, off+n-1)
+	if err != nil {
+		return 0, &fs.PathError{Op: "readat", Path: f.name, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, &fs.PathError{Op: "readat", Path: f.name, Err: ErrNoRange}
+	}
+	k, err := io.ReadFull(resp.Body, p[:n])
+	if err == nil && n < int64(len(p)) {
+		err = io.EOF
+	}
+	return k, err
+}
+
+func (f *file) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
+
+// fileInfo implements fs.FileInfo for a file.
+type fileInfo struct {
+	f *file
+}
+
+func (fi fileInfo) Name() string       { return path.Base(fi.f.name) }
+func (fi fileInfo) Size() int64        { return fi.f.size }
+func (fi fileInfo) Mode() fs.FileMode  { return 0o444 }
+func (fi fileInfo) ModTime() time.Time { return fi.f.mod }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() any           { return nil }
@@ -0,0 +1,175 @@
+package httpfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testContent = bytes.Repeat([]byte("0123456789abcdef"), maxSkip/4)
+
+// newServer returns a server for testContent at /f and an empty file at
+// /empty. Other paths return their name as a status code, such as /403.
+// If ranges is false, Range headers are ignored.
+func newServer(t *testing.T, ranges bool) *FS {
+	mod := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b []byte
+		switch r.URL.Path {
+		case "/f":
+			b = testContent
+		case "/empty":
+		default:
+			code, err := strconv.Atoi(r.URL.Path[1:])
+			if err != nil {
+				code = http.StatusNotFound
+			}
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+		if !ranges {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "", mod, bytes.NewReader(b))
+	}))
+	t.Cleanup(srv.Close)
+	fsys, err := New(context.Background(), srv.URL+"/", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsys
+}
+
+func readAt(f fs.File, off int64, n int) ([]byte, error) {
+	p := make([]byte, n)
+	k, err := f.(io.ReaderAt).ReadAt(p, off)
+	return p[:k], err
+}
+
+func seekRead(f fs.File, off int64, n int) ([]byte, error) {
+	if _, err := f.(io.Seeker).Seek(off, io.SeekStart); err != nil {
+		return nil, err
+	}
+	p := make([]byte, n)
+	k, err := io.ReadFull(f, p)
+	return p[:k], err
+}
+
+func TestRangeReads(t *testing.T) {
+	f, err := newServer(t, true).Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.Size() != int64(len(testContent)) || fi.Name() != "f" || fi.ModTime().Year() != 2020 {
+		t.Fatalf("got %v, %v", fi, err)
+	}
+	for _, test := range []struct {
+		name string
+		off  int64
+		n    int
+	}{
+		{"start", 0, 100},
+		{"short forward seek", 1000, 100},
+		{"long forward seek", 1100 + 2*maxSkip, 100},
+		{"backward seek", 10, 100},
+		{"end", int64(len(testContent)) - 50, 50},
+	} {
+		b, err := seekRead(f, test.off, test.n)
+		if err != nil || !bytes.Equal(b, testContent[test.off:test.off+int64(test.n)]) {
+			t.Errorf("%s: got %d bytes, %v", test.name, len(b), err)
+		}
+	}
+	if n, err := f.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("read at end: got %d, %v, want io.EOF", n, err)
+	}
+	b, err := readAt(f, 5, 10)
+	if err != nil || !bytes.Equal(b, testContent[5:15]) {
+		t.Errorf("ReadAt: got %q, %v", b, err)
+	}
+	b, err = readAt(f, int64(len(testContent))-5, 10)
+	if err != io.EOF || !bytes.Equal(b, testContent[len(testContent)-5:]) {
+		t.Errorf("ReadAt past end: got %q, %v, want io.EOF", b, err)
+	}
+}
+
+func TestNoRange(t *testing.T) {
+	f, err := newServer(t, false).Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if fi, _ := f.Stat(); fi.Size() != int64(len(testContent)) {
+		t.Fatalf("got size %d, want %d", fi.Size(), len(testContent))
+	}
+	// forward seeks read through the response
+	off := int64(2 * maxSkip)
+	b, err := seekRead(f, off, 100)
+	if err != nil || !bytes.Equal(b, testContent[off:off+100]) {
+		t.Fatalf("forward seek: got %d bytes, %v", len(b), err)
+	}
+	if _, err = seekRead(f, 0, 100); !errors.Is(err, ErrNoRange) {
+		t.Errorf("backward seek: got %v, want ErrNoRange", err)
+	}
+	if _, err = readAt(f, 0, 10); !errors.Is(err, ErrNoRange) {
+		t.Errorf("ReadAt: got %v, want ErrNoRange", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	fsys := newServer(t, true)
+	for _, test := range []struct {
+		name string
+		want error
+	}{
+		{"404", fs.ErrNotExist},
+		{"403", fs.ErrPermission},
+		{"missing", fs.ErrNotExist},
+		{"../f", fs.ErrInvalid},
+	} {
+		if _, err := fsys.Open(test.name); !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+	if _, err := fsys.Open("500"); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("500: got %v", err)
+	}
+	f, err := fsys.Open("empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(f); err != nil || len(b) != 0 {
+		t.Errorf("empty: got %q, %v", b, err)
+	}
+	f.Close()
+	if _, err = New(context.Background(), "ftp://example.com/", nil); err == nil {
+		t.Error("ftp: got no error")
+	}
+}
+
+func TestContext(t *testing.T) {
+	fsys := newServer(t, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	fsys.ctx = ctx
+	f, err := fsys.Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cancel()
+	if _, err = seekRead(f, 3*maxSkip, 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("read: got %v, want context.Canceled", err)
+	}
+	if _, err = fsys.Open("f"); !errors.Is(err, context.Canceled) {
+		t.Errorf("open: got %v, want context.Canceled", err)
+	}
+}
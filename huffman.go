@@ -108,6 +108,9 @@ func (h *huffmanDecoder) readSym(r bitReader) (int, error) {
 				break
 			}
 		}
+		if bits > maxCodeLength {
+			return 0, ErrHuffDecodeFailed
+		}
 	} else {
 		v = uint16(n)
 		if v < h.limit[h.quickbits] {
@@ -0,0 +1,58 @@
+package rardecode
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// benchmarkDecode measures reading the first file of arc, containing data.
+func benchmarkDecode(b *testing.B, arc []byte, size int) {
+	b.SetBytes(int64(size))
+	for i := 0; i < b.N; i++ {
+		r, err := NewReader(bytes.NewReader(arc))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = r.Next(); err != nil {
+			b.Fatal(err)
+		}
+		if _, err = io.Copy(io.Discard, r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecode29(b *testing.B) {
+	data := testData(4<<20, 5)
+	benchmarkDecode(b, testArchive15(compressedFile15("f", data)), len(data))
+}
+
+func BenchmarkDecode50(b *testing.B) {
+	data := testData(4<<20, 5)
+	benchmarkDecode(b, testArchive50(0, compressedFile50("f", data, false)), len(data))
+}
+
+// BenchmarkHuffmanReadSym decodes symbols with codes both shorter and longer
+// than maxQuickBits, so both the table lookup and the slower search are used.
+func BenchmarkHuffmanReadSym(b *testing.B) {
+	lengths := append(fixedLengths(8, 4), fixedLengths(64, 8)...)
+	lengths = append(lengths, fixedLengths(mainSize-len(lengths), 12)...)
+	code := newHuffmanCode(lengths)
+	w := new(bitWriter)
+	const n = 1 << 16
+	for i := 0; i < n; i++ {
+		code.write(w, i*7%len(lengths))
+	}
+	var h huffmanDecoder
+	h.init(lengths)
+	b.SetBytes(n)
+	for i := 0; i < b.N; i++ {
+		br := newRarBitReader(newBufByteReader(w.b))
+		for j := 0; j < n; j++ {
+			if _, err := h.readSym(br); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package rardecode
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+const indexVersion = 1 // version of the index format written by SaveIndex
+
+var ErrBadIndex = errors.New("rardecode: archive index invalid or doesn't match archive")
+
+// archiveIndex is the index written by SaveIndex.
+type archiveIndex struct {
+	Version int
+	Files   []indexEntry
+}
+
+// indexEntry is a file stored in an archiveIndex. Sys holds the unexported
+// raw header values of Header, returned by its FileInfo.
+type indexEntry struct {
+	Header   FileHeader
+	Sys      FileSys
+	Position Position
+}
+
+// validVolume reports whether name, the volume of a Position read from an
+// index, is a file name that can't refer outside the archive's directory.
+func validVolume(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// indexSource is where the block header of a File read from an index is found.
+type indexSource struct {
+	name string   // archive name
+	pos  Position // position of the file's first block header
+	opts []Option // options used to open the archive
+	once sync.Once
+	err  error // error returned loading the block header
+}
+
+// load reads the block header of the file named name at the position of s,
+// and returns a packed file reader for it.
+func (s *indexSource) load(name string) (*packedFileReader, error) {
+	pr, err := openPosition(s.name, &s.pos, s.opts)
+	if err != nil {
+		return nil, err
+	}
+	defer pr.Close()
+	h, err := pr.next()
+	if err != nil {
+		return nil, pr.v.wrapErr(err, "")
+	}
+	if h.Name != name {
+		return nil, ErrBadIndex
+	}
+	return pr.clone(), nil
+}
+
+// SaveIndex writes an index of the files in the archive to w, containing each
+// file's header and the position of its block header. OpenWithIndex can then
+// list the files without reading the block headers throughout the archive,
+// which is much faster for large multi-volume archives. The archive is read
+// again from the start like SolidGroups, the state of rc is not changed.
+func (rc *ReadCloser) SaveIndex(w io.Writer) error {
+	fl, err := List(rc.name, rc.opts...)
+	if err != nil {
+		return err
+	}
+	ix := archiveIndex{Version: indexVersion, Files: make([]indexEntry, len(fl))}
+	for i, f := range fl {
+		ix.Files[i] = indexEntry{Header: f.FileHeader, Sys: f.raw, Position: f.pr.pos}
+	}
+	return json.NewEncoder(w).Encode(&ix)
+}
+
+// OpenWithIndex returns the list of File's in the RAR archive specified by
+// name like List, using an index written by SaveIndex instead of reading the
+// archive. A file's block header is only read from the archive when it is
+// opened, and ErrBadIndex is returned if it doesn't match the index, or if a
+// volume name in the index isn't a plain file name. Streams of files read from
+// an index can't be opened.
+func OpenWithIndex(name string, index io.Reader, opts ...Option) ([]*File, error) {
+	var ix archiveIndex
+	if err := json.NewDecoder(index).Decode(&ix); err != nil || ix.Version != indexVersion {
+		return nil, ErrBadIndex
+	}
+	fl := make([]*File, len(ix.Files))
+	for i, e := range ix.Files {
+		if !validVolume(e.Position.Volume) {
+			return nil, ErrBadIndex
+		}
+		e.Header.raw = e.Sys
+		fl[i] = &File{FileHeader: e.Header, src: &indexSource{name: name, pos: e.Position, opts: opts}}
+	}
+	return fl, nil
+}
+
+// packed returns the packed file reader for f, first reading its block header
+// from the archive if f was read from an index.
+func (f *File) packed() (*packedFileReader, error) {
+	if f.src == nil {
+		return f.pr, nil
+	}
+	f.src.once.Do(func() {
+		f.pr, f.src.err = f.src.load(f.Name)
+	})
+	return f.pr, f.src.err
+}
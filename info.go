@@ -0,0 +1,110 @@
+package rardecode
+
+import "io"
+
+// Summary contains totals for the files in an archive.
+type Summary struct {
+	Version          int          // archive format version, FormatRAR15 or FormatRAR50
+	Files            int          // number of files, including directories
+	Volumes          int          // number of volumes
+	PackedSize       int64        // total size of packed file data in all volumes
+	UnPackedSize     int64        // total unpacked size of files with a known size
+	Solid            bool         // archive is solid
+	HeaderEncrypted  bool         // block headers are encrypted
+	HostOS           map[byte]int // number of files created on each HostOS
+	UnKnownSizeFiles int          // number of files with an unknown unpacked size
+}
+
+// ArchiveInfo reads the block headers of the archive specified by name, without
+// decoding any file data, and returns a Summary of the files it contains.
+func ArchiveInfo(name string, opts ...Option) (*Summary, error) {
+	opts = append(opts[:len(opts):len(opts)], func(o *option) { o.hdrOnly = true })
+	pr, err := openPackedFileReader(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer pr.Close()
+
+	s := &Summary{Version: pr.v.ver, HostOS: make(map[byte]int)}
+	for {
+		// read one block at a time so the packed size of every block is counted
+		err = pr.nextBlock()
+		if err == io.EOF {
+			_, err = pr.next()
+		}
+		if err != nil {
+			if err != io.EOF {
+				return nil, pr.v.wrapErr(err, "")
+			}
+			// the volume number is incremented even if the next volume doesn't exist
+			s.Volumes = pr.v.num
+			if pr.v.f != nil {
+				s.Volumes++
+			}
+			return s, nil
+		}
+		h := pr.h
+		s.PackedSize += h.PackedSize
+		s.Solid = s.Solid || h.arcSolid
+		s.HeaderEncrypted = s.HeaderEncrypted || h.HeaderEncrypted
+		if !h.first {
+			continue
+		}
+		s.Files++
+		s.HostOS[h.HostOS]++
+		if h.UnKnownSize {
+			s.UnKnownSizeFiles++
+		} else {
+			s.UnPackedSize += h.UnPackedSize
+		}
+	}
+}
+
+// ProbeInfo describes an archive as found by Probe.
+type ProbeInfo struct {
+	Format          int   // archive format, FormatRAR15 or FormatRAR50
+	SFXSize         int64 // size of any data preceding the RAR signature
+	HeaderEncrypted bool  // block headers are encrypted, so a password is needed to list files
+	FlagsKnown      bool  // the following fields were read from the main archive header
+	Solid           bool  // archive is solid
+	Volume          bool  // archive is a volume of a multi-volume archive
+	FirstVolume     bool  // archive is the first volume of a multi-volume archive
+	Locked          bool  // archive is locked against modification
+	Recovery        bool  // archive has a recovery record
+}
+
+// Probe reads the start of the archive specified by name without using a
+// password, and reports its format and whether its headers are encrypted.
+// Opening an archive with encrypted headers and no password fails with
+// ErrArchiveEncrypted, so Probe can be used to find out a password is needed
+// before asking for one. RAR 5 archives with encrypted headers also encrypt
+// the main archive header, so FlagsKnown is false for them.
+func Probe(name string, opts ...Option) (*ProbeInfo, error) {
+	opts = append(opts[:len(opts):len(opts)], func(o *option) {
+		o.pass = nil
+		o.passFn = nil
+		o.passes = nil
+		o.hdrOnly = true
+	})
+	pr, err := openPackedFileReader(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer pr.Close()
+
+	p := &ProbeInfo{Format: pr.v.ver, SFXSize: pr.v.sfx}
+	switch _, err = pr.next(); err {
+	case nil, io.EOF:
+	case ErrArchiveEncrypted:
+		p.HeaderEncrypted = true
+	default:
+		return nil, pr.v.wrapErr(err, "")
+	}
+	if p.Format == FormatRAR15 || !p.HeaderEncrypted {
+		a := pr.v.arc
+		p.FlagsKnown = true
+		p.Solid, p.Volume, p.FirstVolume = a.solid, a.volume, a.first
+		p.Locked, p.Recovery = a.locked, a.recovery
+	}
+	return p, nil
+}
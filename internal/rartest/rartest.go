@@ -0,0 +1,276 @@
+// Package rartest constructs RAR archive data for tests, so header edge cases
+// can be tested without binary fixtures. Blocks can be built from the File
+// and Main types, or from raw fields with Block15 and Block50 to produce
+// headers that no archiver would write. Header checksums are always valid.
+package rartest
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// Archive signatures.
+const (
+	Sig15 = "Rar!\x1a\x07\x00"     // RAR 1.5 to 4.x format
+	Sig50 = "Rar!\x1a\x07\x01\x00" // RAR 5 format
+)
+
+// RAR 5 block types.
+const (
+	Block50Arc     = 1
+	Block50File    = 2
+	Block50Service = 3
+	Block50Encrypt = 4
+	Block50End     = 5
+)
+
+// RAR 1.5 block types.
+const (
+	Block15Arc     = 0x73
+	Block15File    = 0x74
+	Block15Service = 0x7a
+	Block15End     = 0x7b
+)
+
+// Archive returns an archive containing sig followed by blocks.
+func Archive(sig string, blocks ...[]byte) []byte {
+	b := []byte(sig)
+	for _, blk := range blocks {
+		b = append(b, blk...)
+	}
+	return b
+}
+
+// Uvarint returns x encoded as a RAR 5 variable length integer.
+func Uvarint(x uint64) []byte {
+	return binary.AppendUvarint(nil, x)
+}
+
+// PaddedUvarint returns x encoded as a RAR 5 variable length integer of n
+// bytes, using redundant continuation bytes if needed. RAR 5 allows this so
+// that header sizes can be filled in after the header is written.
+func PaddedUvarint(x uint64, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(x & 0x7f)
+		x >>= 7
+		if i < n-1 {
+			b[i] |= 0x80
+		}
+	}
+	return b
+}
+
+// Record50 returns a RAR 5 extra area record of type rtype.
+func Record50(rtype uint64, data []byte) []byte {
+	r := append(Uvarint(rtype), data...)
+	return append(Uvarint(uint64(len(r))), r...)
+}
+
+// Block50 returns a RAR 5 block. fields are the header fields specific to
+// htype. If extra isn't nil it is stored as the extra area, and if data isn't
+// nil it follows the header, with the block flags set to match.
+func Block50(htype, flags uint64, fields, extra, data []byte) []byte {
+	if extra != nil {
+		flags |= 0x0001
+	}
+	if data != nil {
+		flags |= 0x0002
+	}
+	h := append(Uvarint(htype), Uvarint(flags)...)
+	if extra != nil {
+		h = append(h, Uvarint(uint64(len(extra)))...)
+	}
+	if data != nil {
+		h = append(h, Uvarint(uint64(len(data)))...)
+	}
+	h = append(h, fields...)
+	h = append(h, extra...)
+	h = append(Uvarint(uint64(len(h))), h...)
+	b := binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(h))
+	b = append(b, h...)
+	return append(b, data...)
+}
+
+// Main50 returns a RAR 5 main archive block with the given archive flags.
+func Main50(flags uint64) []byte {
+	return Block50(Block50Arc, 0, Uvarint(flags), nil, nil)
+}
+
+// End50 returns a RAR 5 end of archive block with the given flags.
+func End50(flags uint64) []byte {
+	return Block50(Block50End, 0, Uvarint(flags), nil, nil)
+}
+
+// File50 describes a RAR 5 file or service block.
+type File50 struct {
+	HeaderType  uint64 // Block50File or Block50Service
+	BlockFlags  uint64 // flags common to all blocks, such as those for split files
+	Flags       uint64 // file flags, 0x2 stores MTime and 0x4 stores CRC
+	Size        uint64 // unpacked size
+	Attributes  uint64
+	MTime       uint32 // unix modification time
+	CRC         uint32 // crc32 of the unpacked data
+	Compression uint64 // compression information, including the dictionary size
+	HostOS      uint64
+	Name        string
+	Extra       []byte // extra area records, see Record50
+	Data        []byte // packed data, no data area if nil
+}
+
+// NewFile50 returns a File50 for a stored file with the given name and contents.
+func NewFile50(name string, data []byte) *File50 {
+	if data == nil {
+		data = []byte{}
+	}
+	return &File50{
+		HeaderType: Block50File,
+		Flags:      0x4,
+		Size:       uint64(len(data)),
+		Attributes: 0o644,
+		CRC:        crc32.ChecksumIEEE(data),
+		HostOS:     1,
+		Name:       name,
+		Data:       data,
+	}
+}
+
+// Bytes returns the block for f.
+func (f *File50) Bytes() []byte {
+	b := Uvarint(f.Flags)
+	b = append(b, Uvarint(f.Size)...)
+	b = append(b, Uvarint(f.Attributes)...)
+	if f.Flags&0x2 > 0 {
+		b = binary.LittleEndian.AppendUint32(b, f.MTime)
+	}
+	if f.Flags&0x4 > 0 {
+		b = binary.LittleEndian.AppendUint32(b, f.CRC)
+	}
+	b = append(b, Uvarint(f.Compression)...)
+	b = append(b, Uvarint(f.HostOS)...)
+	b = append(b, Uvarint(uint64(len(f.Name)))...)
+	b = append(b, f.Name...)
+	return Block50(f.HeaderType, f.BlockFlags, b, f.Extra, f.Data)
+}
+
+// Block15 returns a RAR 1.5 block. fields are the header fields following the
+// common ones, which must start with the 4 byte data size for blocks with data.
+// If data isn't nil it follows the header, with the flag for it set.
+func Block15(htype byte, flags uint16, fields, data []byte) []byte {
+	if data != nil {
+		flags |= 0x8000
+	}
+	h := []byte{htype}
+	h = binary.LittleEndian.AppendUint16(h, flags)
+	h = binary.LittleEndian.AppendUint16(h, uint16(7+len(fields)))
+	h = append(h, fields...)
+	b := binary.LittleEndian.AppendUint16(nil, uint16(crc32.ChecksumIEEE(h)))
+	b = append(b, h...)
+	return append(b, data...)
+}
+
+// Main15 returns a RAR 1.5 main archive block with the given archive flags.
+func Main15(flags uint16) []byte {
+	return Block15(Block15Arc, flags, make([]byte, 6), nil)
+}
+
+// End15 returns a RAR 1.5 end of archive block with the given flags.
+func End15(flags uint16) []byte {
+	return Block15(Block15End, flags, nil, nil)
+}
+
+// File15 describes a RAR 1.5 file or service block.
+type File15 struct {
+	HeaderType byte   // Block15File or Block15Service
+	Flags      uint16 // block flags, including the dictionary size and split file flags
+	Size       uint32 // unpacked size
+	HostOS     byte
+	CRC        uint32 // crc32 of the unpacked data
+	Time       uint32 // MS-DOS modification time
+	Version    byte   // version needed to extract
+	Method     byte   // compression method, 0x30 for stored
+	Attributes uint32
+	Name       string
+	Extra      []byte // fields following the name, such as the salt or extended times
+	Data       []byte // packed data
+}
+
+// NewFile15 returns a File15 for a stored file with the given name and contents.
+func NewFile15(name string, data []byte) *File15 {
+	if data == nil {
+		data = []byte{}
+	}
+	return &File15{
+		HeaderType: Block15File,
+		Size:       uint32(len(data)),
+		HostOS:     2,
+		CRC:        crc32.ChecksumIEEE(data),
+		Time:       0x5a8e3100,
+		Version:    29,
+		Method:     0x30,
+		Attributes: 0o100644<<16 | 0x20,
+		Name:       name,
+		Data:       data,
+	}
+}
+
+// Bytes returns the block for f.
+func (f *File15) Bytes() []byte {
+	b := binary.LittleEndian.AppendUint32(nil, uint32(len(f.Data)))
+	b = binary.LittleEndian.AppendUint32(b, f.Size)
+	b = append(b, f.HostOS)
+	b = binary.LittleEndian.AppendUint32(b, f.CRC)
+	b = binary.LittleEndian.AppendUint32(b, f.Time)
+	b = append(b, f.Version, f.Method)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(f.Name)))
+	b = binary.LittleEndian.AppendUint32(b, f.Attributes)
+	b = append(b, f.Name...)
+	b = append(b, f.Extra...)
+	return Block15(f.HeaderType, f.Flags, b, f.Data)
+}
+
+// Corpus returns a deterministic set of small archives with unusual but
+// parseable headers, for use as a fuzzing seed corpus.
+func Corpus() [][]byte {
+	var c [][]byte
+	add50 := func(blocks ...[]byte) {
+		c = append(c, Archive(Sig50, append(append([][]byte{Main50(0)}, blocks...), End50(0))...))
+	}
+	add15 := func(blocks ...[]byte) {
+		c = append(c, Archive(Sig15, append(append([][]byte{Main15(0)}, blocks...), End15(0))...))
+	}
+
+	add50(NewFile50("a.txt", []byte("hello\n")).Bytes())
+	add50(NewFile50("", []byte("no name")).Bytes())
+	f := NewFile50("huge", nil)
+	f.Size = 1<<63 - 1
+	f.Attributes = 1<<64 - 1
+	add50(f.Bytes())
+	fields := append(PaddedUvarint(0x4, 10), PaddedUvarint(1, 10)...) // flags and size
+	fields = append(fields, Uvarint(0o644)...)
+	fields = binary.LittleEndian.AppendUint32(fields, crc32.ChecksumIEEE([]byte("x")))
+	fields = append(fields, 0, 1, 6) // compression, host os and name size
+	add50(Block50(Block50File, 0, append(fields, "padded"...), nil, []byte("x")))
+	f = NewFile50("dict", []byte("x"))
+	f.Compression = 5<<7 | 0x1f<<10 | 0x1f<<15 // best method, maximum dictionary size and fraction
+	add50(f.Bytes())
+	f = NewFile50("unknown.rec", []byte("x"))
+	f.Extra = Record50(99, []byte("?"))
+	add50(f.Bytes())
+	f = NewFile50("split", []byte("part"))
+	f.BlockFlags = 0x0010
+	add50(f.Bytes())
+	add50(NewFile50("dir/", nil).Bytes(), Block50(99, 0, nil, nil, []byte("unknown block")))
+
+	add15(NewFile15("a.txt", []byte("hello\n")).Bytes())
+	add15(NewFile15("", []byte("no name")).Bytes())
+	f15 := NewFile15("dict", []byte("x"))
+	f15.Flags = 0x00c0 // largest dictionary size that isn't a directory
+	f15.Method = 0x35
+	add15(f15.Bytes())
+	f15 = NewFile15("size", nil)
+	f15.Size = 1<<32 - 1
+	add15(f15.Bytes())
+	add15(Block15(0x7e, 0, binary.LittleEndian.AppendUint32(nil, 4), []byte("blks")))
+	return c
+}
@@ -0,0 +1,47 @@
+//go:build go1.23
+
+package rardecode
+
+import (
+	"errors"
+	"io"
+	"iter"
+)
+
+var errStopBlocks = errors.New("rardecode: block iteration stopped")
+
+// Files returns an iterator over the remaining files in the archive. Each file
+// header is yielded after calling Next, so the file contents can be read from r
+// inside the loop. Iteration stops after the first error is yielded, and io.EOF
+// at the end of the archive is not reported.
+func (r *Reader) Files() iter.Seq2[*FileHeader, error] {
+	return func(yield func(*FileHeader, error) bool) {
+		for {
+			h, err := r.Next()
+			if err == io.EOF {
+				return
+			}
+			if !yield(h, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Blocks returns an iterator over the block headers of the archive read from r,
+// as described for WalkBlocks. Iteration stops after the first error is yielded.
+func Blocks(r io.Reader, opts ...Option) iter.Seq2[*Block, error] {
+	return func(yield func(*Block, error) bool) {
+		stopped := false
+		err := WalkBlocks(r, func(b *Block) error {
+			if !yield(b, nil) {
+				stopped = true
+				return errStopBlocks
+			}
+			return nil
+		}, opts...)
+		if err != nil && !stopped {
+			yield(nil, err)
+		}
+	}
+}
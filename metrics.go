@@ -0,0 +1,51 @@
+package rardecode
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Metrics counts the resources used while reading archives, so long running
+// services can monitor for archives that use excessive memory or processing.
+// The counts are totals since the Metrics was created. A Metrics may be shared
+// by many readers and is safe for concurrent use. It implements expvar.Var, so
+// it can be published with expvar.Publish.
+type Metrics struct {
+	WindowBytes    atomic.Int64 // bytes of decode windows allocated, excluding UseExternalWindow windows
+	PPMBytes       atomic.Int64 // bytes of PPM model memory allocated
+	FiltersQueued  atomic.Int64 // filters queued to be applied to decoded data
+	DecodedBytes   atomic.Int64 // bytes output by decoders, before filters are applied
+	DecryptedBytes atomic.Int64 // bytes of file data decrypted
+	VMInstructions atomic.Int64 // instructions run by RAR 2.9 VM filters
+	VMOutputBytes  atomic.Int64 // bytes output by RAR 2.9 VM filters
+}
+
+// CollectMetrics adds the resources used by readers to m.
+func CollectMetrics(m *Metrics) Option {
+	return func(o *option) { o.stats = m }
+}
+
+// String returns the counts in m as a JSON object.
+func (m *Metrics) String() string {
+	return fmt.Sprintf(`{"WindowBytes":%d,"PPMBytes":%d,"FiltersQueued":%d,"DecodedBytes":%d,"DecryptedBytes":%d,"VMInstructions":%d,"VMOutputBytes":%d}`,
+		m.WindowBytes.Load(), m.PPMBytes.Load(), m.FiltersQueued.Load(), m.DecodedBytes.Load(), m.DecryptedBytes.Load(),
+		m.VMInstructions.Load(), m.VMOutputBytes.Load())
+}
+
+// countReader is a byteReader that adds the number of bytes read to n.
+type countReader struct {
+	r byteReader
+	n *atomic.Int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c *countReader) bytes() ([]byte, error) {
+	b, err := c.r.bytes()
+	c.n.Add(int64(len(b)))
+	return b, err
+}
@@ -0,0 +1,104 @@
+package rardecode
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// mappedFile is a volume file mapped into memory by the Mmap option.
+type mappedFile struct {
+	*bytes.Reader
+	b []byte   // mapped file data
+	f *os.File // file that was mapped
+}
+
+func (m *mappedFile) Stat() (fs.FileInfo, error) { return m.f.Stat() }
+
+func (m *mappedFile) Close() error {
+	err := munmap(m.b)
+	if cerr := m.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// openMapped opens the named file and maps it into memory. If the file can't
+// be mapped, such as when it is empty or mapping isn't supported, the opened
+// file is returned instead.
+func openMapped(name string) (fs.File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() <= 0 || int64(int(fi.Size())) != fi.Size() {
+		return f, nil
+	}
+	// the mapping is copy-on-write, as encrypted data is decrypted in place
+	b, err := mmap(f, int(fi.Size()), false)
+	if err != nil {
+		return f, nil
+	}
+	return &mappedFile{Reader: bytes.NewReader(b), b: b, f: f}, nil
+}
+
+// memReader is used by a volume in place of a bufio.Reader to read a
+// mappedFile. It acts as though the whole file has been read into its buffer,
+// which is the mapped data, so the slices it returns aren't copies.
+type memReader struct {
+	b   []byte // mapped file data
+	off int    // offset of the next byte to read
+}
+
+// Reset continues reading from the current offset of r, which must be a
+// *mappedFile, and moves that offset to the end of the file like a
+// bufio.Reader filling its buffer would.
+func (r *memReader) Reset(f io.Reader) {
+	m := f.(*mappedFile)
+	off, _ := m.Seek(0, io.SeekCurrent)
+	_, _ = m.Seek(0, io.SeekEnd)
+	r.b = m.b
+	r.off = int(min(off, int64(len(m.b))))
+}
+
+func (r *memReader) Size() int     { return len(r.b) }
+func (r *memReader) Buffered() int { return len(r.b) - r.off }
+
+func (r *memReader) Peek(n int) ([]byte, error) {
+	b := r.b[r.off:]
+	if n > len(b) {
+		return b, io.EOF
+	}
+	return b[:n], nil
+}
+
+func (r *memReader) Discard(n int) (int, error) {
+	k := min(n, r.Buffered())
+	r.off += k
+	if k < n {
+		return k, io.EOF
+	}
+	return k, nil
+}
+
+func (r *memReader) Read(p []byte) (int, error) {
+	if r.off == len(r.b) && len(p) > 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.b[r.off:])
+	r.off += n
+	return n, nil
+}
+
+func (r *memReader) ReadSlice(delim byte) ([]byte, error) {
+	b := r.b[r.off:]
+	i := bytes.IndexByte(b, delim)
+	if i < 0 {
+		r.off = len(r.b)
+		return b, io.EOF
+	}
+	r.off += i + 1
+	return b[:i+1], nil
+}
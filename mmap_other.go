@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package rardecode
+
+import (
+	"errors"
+	"os"
+)
+
+func mmap(f *os.File, size int, shared bool) ([]byte, error) {
+	return nil, errors.New("rardecode: mmap not supported")
+}
+
+func munmap(b []byte) error { return nil }
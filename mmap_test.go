@@ -0,0 +1,86 @@
+package rardecode
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nwaples/rardecode/v2/internal/rartest"
+)
+
+func TestMmap(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, b []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := testData(100000, 7)
+	arc := testArchive50(0,
+		compressedFile50("c", data, false),
+		encryptedFile50("e", data[:5000], "password"),
+		rartest.NewFile50("s", data[:3000]).Bytes(),
+	)
+	write("a.rar", arc)
+	parts := [][]byte{testData(5000, 1), testData(3000, 2), testData(7000, 3)}
+	for name, f := range splitArchive50("f", parts...) {
+		write(name, f.Data)
+	}
+	for _, test := range []struct {
+		name  string
+		files map[string][]byte
+	}{
+		{"a.rar", map[string][]byte{"c": data, "e": data[:5000], "s": data[:3000]}},
+		{"v.part1.rar", map[string][]byte{"f": bytes.Join(parts, nil)}},
+	} {
+		name := filepath.Join(dir, test.name)
+		opts := []Option{Mmap(true), Password("password")}
+		rc, err := OpenReader(name, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for {
+			h, err := rc.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			b, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatalf("%s: %s: %v", test.name, h.Name, err)
+			}
+			if !bytes.Equal(b, test.files[h.Name]) {
+				t.Fatalf("%s: %s: data mismatch", test.name, h.Name)
+			}
+			n++
+		}
+		rc.Close()
+		if n != len(test.files) {
+			t.Fatalf("%s: got %d files, want %d", test.name, n, len(test.files))
+		}
+		// open the files in reverse, so the volumes are seeked
+		files, err := List(name, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := len(files) - 1; i >= 0; i-- {
+			r, err := files[i].Open()
+			if err != nil {
+				t.Fatalf("%s: %s: %v", test.name, files[i].Name, err)
+			}
+			b, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || !bytes.Equal(b, test.files[files[i].Name]) {
+				t.Fatalf("%s: %s: opened file data mismatch: %v", test.name, files[i].Name, err)
+			}
+		}
+	}
+	// encrypted data is decrypted in place, which mustn't change the file
+	if b, err := os.ReadFile(filepath.Join(dir, "a.rar")); err != nil || !bytes.Equal(b, arc) {
+		t.Fatalf("archive changed by reading it: %v", err)
+	}
+}
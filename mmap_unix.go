@@ -0,0 +1,20 @@
+//go:build unix
+
+package rardecode
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmap maps size bytes of f into memory. Writes to the memory change the file
+// if shared is set, otherwise they only change a private copy of the pages.
+func mmap(f *os.File, size int, shared bool) ([]byte, error) {
+	flags := syscall.MAP_PRIVATE
+	if shared {
+		flags = syscall.MAP_SHARED
+	}
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, flags)
+}
+
+func munmap(b []byte) error { return syscall.Munmap(b) }
@@ -0,0 +1,36 @@
+//go:build windows
+
+package rardecode
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func mmap(f *os.File, size int, shared bool) ([]byte, error) {
+	prot, access := uint32(syscall.PAGE_WRITECOPY), uint32(syscall.FILE_MAP_COPY)
+	if shared {
+		prot, access = syscall.PAGE_READWRITE, syscall.FILE_MAP_WRITE
+	}
+	n := uint64(size)
+	h, err := syscall.CreateFileMapping(syscall.Handle(f.Fd()), nil, prot, uint32(n>>32), uint32(n), nil)
+	if err != nil {
+		return nil, os.NewSyscallError("CreateFileMapping", err)
+	}
+	// the view keeps the mapping open after its handle is closed
+	defer syscall.CloseHandle(h)
+	addr, err := syscall.MapViewOfFile(h, access, 0, 0, uintptr(size))
+	if err != nil {
+		return nil, os.NewSyscallError("MapViewOfFile", err)
+	}
+	// addr is memory mapped by the system rather than allocated by Go, so it
+	// can't move and is safe to convert to a pointer
+	var p *byte
+	*(*uintptr)(unsafe.Pointer(&p)) = addr
+	return unsafe.Slice(p, size), nil
+}
+
+func munmap(b []byte) error {
+	return syscall.UnmapViewOfFile(uintptr(unsafe.Pointer(&b[0])))
+}
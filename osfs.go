@@ -0,0 +1,24 @@
+package rardecode
+
+import (
+	"io/fs"
+	"os"
+)
+
+// osFS is the fs.FS used to open volumes by name when the FileSystem option
+// isn't set. Unlike os.DirFS it accepts any name the os package does, such as
+// absolute paths. Volumes are only opened with the os package through osFS,
+// so on platforms without a file system, such as js/wasm, archives can be read
+// entirely from memory with FileSystem, NewReader or OpenReaderAt.
+type osFS struct {
+	mmap bool // map files into memory, set by the Mmap option
+}
+
+func (o osFS) Open(name string) (fs.File, error) {
+	if o.mmap {
+		return openMapped(name)
+	}
+	return os.Open(name)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
@@ -0,0 +1,72 @@
+package rardecode
+
+// PlannedFile is a file that needs to be decoded to extract the targets of an
+// ExtractionPlan.
+type PlannedFile struct {
+	*File
+	Target bool // file is a target, otherwise it is decoded and discarded to reach one
+}
+
+// ExtractionPlan describes the files that need to be decoded to extract a set
+// of target files, as returned by PlanExtraction.
+type ExtractionPlan struct {
+	Files        []PlannedFile // files to decode in archive order
+	Missing      []string      // targets not found in the archive
+	DecodeBytes  int64         // unpacked bytes decoded, including discarded files
+	DiscardBytes int64         // unpacked bytes decoded only to reach a target
+}
+
+// PlanExtraction returns a plan for extracting the files named by targets.
+// In a solid archive, each target requires the compressed files before it in
+// its solid group to be decoded first, and files after the last target in a
+// group don't need to be decoded. Reading the archive with Next for the planned
+// files and Skip for the others only decodes the files in the plan. Byte counts
+// exclude files of unknown size. Files not kept by the DuplicatePolicy option
+// aren't targets, but may still need to be decoded. The archive is read again
+// from the start like SolidGroups, the state of rc is not changed.
+func (rc *ReadCloser) PlanExtraction(targets []string) (*ExtractionPlan, error) {
+	groups, dropped, err := rc.solidGroups()
+	if err != nil {
+		return nil, err
+	}
+	want := make(map[string]bool, len(targets))
+	for _, name := range targets {
+		want[name] = false
+	}
+	// files that aren't decoded don't change the decode state
+	decoded := func(f *File) bool { return f.pr.h.decVer > 0 && !f.pr.h.hasNoData() }
+	p := new(ExtractionPlan)
+	for _, g := range groups {
+		last := -1 // index of the last target in g that is decoded
+		for i, f := range g {
+			if _, ok := want[f.Name]; ok && !dropped[f] {
+				want[f.Name] = true
+				if decoded(f) {
+					last = i
+				}
+			}
+		}
+		for i, f := range g {
+			_, target := want[f.Name]
+			target = target && !dropped[f]
+			if !target && (i > last || !decoded(f)) {
+				continue
+			}
+			p.Files = append(p.Files, PlannedFile{File: f, Target: target})
+			if f.UnKnownSize || f.UnPackedSize < 0 {
+				continue
+			}
+			p.DecodeBytes += f.UnPackedSize
+			if !target {
+				p.DiscardBytes += f.UnPackedSize
+			}
+		}
+	}
+	for _, name := range targets {
+		if !want[name] {
+			p.Missing = append(p.Missing, name)
+			want[name] = true // only report duplicate targets once
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,109 @@
+package rardecode
+
+import (
+	"fmt"
+	"testing"
+	"testing/fstest"
+)
+
+// groupNames returns the names of the files in each group.
+func groupNames(groups [][]*File) [][]string {
+	names := make([][]string, len(groups))
+	for i, g := range groups {
+		for _, f := range g {
+			names[i] = append(names[i], f.Name)
+		}
+	}
+	return names
+}
+
+func TestSolidGroupsDuplicates(t *testing.T) {
+	arc := testArchive50(0x4, // solid archive
+		compressedFile50("a", testData(1000, 1), false),
+		compressedFile50("b", testData(1000, 2), true),
+		compressedFile50("a", testData(1000, 3), true),
+	)
+	fsys := fstest.MapFS{"a.rar": &fstest.MapFile{Data: arc}}
+	rc, err := OpenReader("a.rar", FileSystem(fsys), DuplicatePolicy(DuplicateKeepLast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	// the first file, which starts the group, isn't kept
+	groups, err := rc.SolidGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := groupNames(groups); len(got) != 1 || len(got[0]) != 2 || got[0][0] != "b" || got[0][1] != "a" {
+		t.Fatalf("got groups %q, want [[b a]]", got)
+	}
+	p, err := rc.PlanExtraction([]string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Files) != 3 || p.Files[0].Target || p.Files[1].Target || !p.Files[2].Target {
+		t.Fatalf("got %d planned files, want the first two decoded to reach the last", len(p.Files))
+	}
+}
+
+func TestSolidGroupsAndPlan(t *testing.T) {
+	// solid files are named in upper case, making the archive solid
+	archive := func(names string) fstest.MapFS {
+		var blocks [][]byte
+		var flags uint64
+		for i, c := range names {
+			name := string(c | 0x20)
+			if c < 'a' {
+				flags = 0x4 // solid archive
+			}
+			blocks = append(blocks, compressedFile50(name, testData(1000, byte(i)), c < 'a'))
+		}
+		return fstest.MapFS{"a.rar": &fstest.MapFile{Data: testArchive50(flags, blocks...)}}
+	}
+	for _, test := range []struct {
+		names   string
+		targets []string
+		groups  string
+		plan    string // planned files, upper case for targets
+		missing []string
+		discard int64
+	}{
+		{"aBCdE", []string{"b"}, "[[a b c] [d e]]", "aB", nil, 1000},
+		{"aBCdE", []string{"e", "a", "x"}, "[[a b c] [d e]]", "AdE", []string{"x"}, 1000},
+		{"aBCdE", []string{"c", "d"}, "[[a b c] [d e]]", "abCD", nil, 2000},
+		{"abc", []string{"b"}, "[[a] [b] [c]]", "B", nil, 0},
+		{"abc", nil, "[[a] [b] [c]]", "", nil, 0},
+	} {
+		rc, err := OpenReader("a.rar", FileSystem(archive(test.names)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups, err := rc.SolidGroups()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(groupNames(groups)); got != test.groups {
+			t.Errorf("%s: got groups %s, want %s", test.names, got, test.groups)
+		}
+		p, err := rc.PlanExtraction(test.targets)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var plan string
+		for _, f := range p.Files {
+			if f.Target {
+				plan += string(f.Name[0] &^ 0x20)
+			} else {
+				plan += f.Name
+			}
+		}
+		if plan != test.plan || fmt.Sprint(p.Missing) != fmt.Sprint(test.missing) {
+			t.Errorf("%s: targets %q: got plan %q, missing %q, want %q, %q", test.names, test.targets, plan, p.Missing, test.plan, test.missing)
+		}
+		if want := int64(len(test.plan)) * 1000; p.DecodeBytes != want || p.DiscardBytes != test.discard {
+			t.Errorf("%s: targets %q: got %d decoded and %d discarded bytes, want %d and %d",
+				test.names, test.targets, p.DecodeBytes, p.DiscardBytes, want, test.discard)
+		}
+	}
+}
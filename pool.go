@@ -0,0 +1,74 @@
+package rardecode
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// Decode windows and PPM model memory can be many megabytes in size, so they
+// are kept in pools for reuse once a ReadCloser is closed. This avoids a new
+// allocation for each file when many archives are being extracted. The pools
+// are keyed by slice length, as windows are usually one of a small set of sizes.
+// Read buffers used by the AdaptiveBuffer option are pooled the same way.
+var (
+	windowPools sync.Map // map[int]*sync.Pool of *[]byte
+	statePools  sync.Map // map[int]*sync.Pool of *[]state
+	readerPools sync.Map // map[int]*sync.Pool of *bufio.Reader
+)
+
+// getPool returns the sync.Pool for slices of length n from pools.
+func getPool(pools *sync.Map, n int) *sync.Pool {
+	if p, ok := pools.Load(n); ok {
+		return p.(*sync.Pool)
+	}
+	p, _ := pools.LoadOrStore(n, new(sync.Pool))
+	return p.(*sync.Pool)
+}
+
+// getWindow returns a zeroed window of the given size.
+func getWindow(size int) []byte {
+	if b, ok := getPool(&windowPools, size).Get().(*[]byte); ok {
+		clear(*b)
+		return *b
+	}
+	return make([]byte, size)
+}
+
+// putWindow returns a window to the pool for reuse.
+func putWindow(b []byte) {
+	if len(b) > 0 {
+		getPool(&windowPools, len(b)).Put(&b)
+	}
+}
+
+// getStates returns a slice of n states for use by a subAllocator.
+// The states are not zeroed.
+func getStates(n int) []state {
+	if s, ok := getPool(&statePools, n).Get().(*[]state); ok {
+		return *s
+	}
+	return make([]state, n)
+}
+
+// putStates returns a slice of states to the pool for reuse.
+func putStates(s []state) {
+	if len(s) > 0 {
+		getPool(&statePools, len(s)).Put(&s)
+	}
+}
+
+// getReader returns a bufio.Reader of the given size reading from r.
+func getReader(r io.Reader, size int) *bufio.Reader {
+	if br, ok := getPool(&readerPools, size).Get().(*bufio.Reader); ok {
+		br.Reset(r)
+		return br
+	}
+	return bufio.NewReaderSize(r, size)
+}
+
+// putReader returns a bufio.Reader to the pool for reuse.
+func putReader(br *bufio.Reader) {
+	br.Reset(nil)
+	getPool(&readerPools, br.Size()).Put(br)
+}
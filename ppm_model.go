@@ -27,11 +27,18 @@ const (
 	// A unit can store one context or two states.
 	unitSize = 12
 
+	maxPPMMemoryMB = 256 // maximum model memory in megabytes
+
 	freeMark = -1
 )
 
 var (
-	ErrCorruptPPM = errors.New("rardecode: corrupt ppm data")
+	ErrCorruptPPM        = errors.New("rardecode: corrupt ppm data")
+	ErrPPMMemoryTooLarge = errors.New("rardecode: ppm model memory too large")
+
+	// errPPMNoMemory is returned by the subAllocator when there isn't enough free
+	// memory for an allocation. The model is restarted when this occurs.
+	errPPMNoMemory = errors.New("rardecode: ppm model out of memory")
 
 	expEscape  = []byte{25, 14, 9, 7, 5, 5, 4, 4, 4, 3, 3, 3, 2, 2, 2, 2}
 	initBinEsc = []uint16{0x3CDD, 0x1F3F, 0x59BF, 0x48F3, 0x64A1, 0x5ABC, 0x6632, 0x6051}
@@ -102,9 +109,12 @@ func (r *rangeCoder) init(br io.ByteReader) error {
 	return nil
 }
 
-func (r *rangeCoder) currentCount(scale uint32) uint32 {
+func (r *rangeCoder) currentCount(scale uint32) (uint32, error) {
 	r.rnge /= scale
-	return (r.code - r.low) / r.rnge
+	if r.rnge == 0 {
+		return 0, ErrCorruptPPM
+	}
+	return (r.code - r.low) / r.rnge, nil
 }
 
 func (r *rangeCoder) normalize() error {
@@ -227,7 +237,12 @@ type subAllocator struct {
 	states []state
 }
 
-func (a *subAllocator) init(maxMB int) {
+// init allocates maxMB megabytes of memory for the model. It returns
+// ErrCorruptPPM if the size is outside the range allowed by the format.
+func (a *subAllocator) init(maxMB int) error {
+	if maxMB < 1 || maxMB > maxPPMMemoryMB {
+		return ErrCorruptPPM
+	}
 	bytes := int32(maxMB) << 20
 	heap2Units := bytes / 8 / unitSize * 7
 	a.heap1MaxBytes = bytes - heap2Units*unitSize
@@ -239,11 +254,24 @@ func (a *subAllocator) init(maxMB int) {
 	if cap(a.states) > n {
 		a.states = a.states[:n]
 	} else {
-		a.states = make([]state, n)
+		putStates(a.states)
+		a.states = getStates(n)
 	}
+	return nil
+}
+
+// release returns the allocator's memory to the pool.
+func (a *subAllocator) release() {
+	putStates(a.states[:cap(a.states)])
+	a.states = nil
 }
 
-func (a *subAllocator) restart() {
+// restart frees all allocated memory. It returns ErrCorruptPPM if the allocator
+// has no memory, which happens if the model was never initialized.
+func (a *subAllocator) restart() error {
+	if len(a.states) == 0 {
+		return ErrCorruptPPM
+	}
 	// Pad heap1 start by 1 unit and enough bytes so that there is no
 	// gap between heap1 end and heap2 start.
 	a.heap1Lo = unitSize + (unitSize - a.heap1MaxBytes%unitSize)
@@ -252,11 +280,12 @@ func (a *subAllocator) restart() {
 	a.heap2Hi = int32(len(a.states))
 	a.glueCount = 0
 	clear(a.freeList[:])
+	return nil
 }
 
 // pushByte puts a byte on the heap and returns a state.succ index that
 // can be used to retrieve it.
-func (a *subAllocator) pushByte(c byte) int32 {
+func (a *subAllocator) pushByte(c byte) (int32, error) {
 	si := a.heap1Lo / 6 // state index
 	oi := a.heap1Lo % 6 // byte position in state
 	switch oi {
@@ -273,9 +302,9 @@ func (a *subAllocator) pushByte(c byte) int32 {
 	}
 	a.heap1Lo++
 	if a.heap1Lo >= a.heap1Hi {
-		return 0
+		return 0, errPPMNoMemory
 	}
-	return -a.heap1Lo
+	return -a.heap1Lo, nil
 }
 
 // popByte reverses the previous pushByte
@@ -377,12 +406,12 @@ func (a *subAllocator) glueFreeBlocks() {
 	}
 }
 
-func (a *subAllocator) allocUnitsRare(index byte) int32 {
+func (a *subAllocator) allocUnitsRare(index byte) (int32, error) {
 	if a.glueCount == 0 {
 		a.glueCount = 255
 		a.glueFreeBlocks()
 		if n := a.removeFreeBlock(index); n > 0 {
-			return n
+			return n, nil
 		}
 	}
 	// try to find a larger free block and split it
@@ -390,7 +419,7 @@ func (a *subAllocator) allocUnitsRare(index byte) int32 {
 		if n := a.removeFreeBlock(i); n > 0 {
 			u := index2Units[i] - index2Units[index]
 			a.freeUnits(n+index2Units[index]<<1, u)
-			return n
+			return n, nil
 		}
 	}
 	a.glueCount--
@@ -399,51 +428,60 @@ func (a *subAllocator) allocUnitsRare(index byte) int32 {
 	n := a.heap1Hi - index2Units[index]*unitSize
 	if n > a.heap1Lo {
 		a.heap1Hi = n
-		return a.heap1Hi / unitSize * 2
+		return a.heap1Hi / unitSize * 2, nil
 	}
-	return 0
+	return 0, errPPMNoMemory
 }
 
-func (a *subAllocator) allocUnits(i byte) int32 {
+func (a *subAllocator) allocUnits(i byte) (int32, error) {
 	// try to allocate a free block
 	if n := a.removeFreeBlock(i); n > 0 {
-		return n
+		return n, nil
 	}
 	// try to allocate from the bottom of heap2
 	n := index2Units[i] << 1
 	if a.heap2Lo+n <= a.heap2Hi {
 		lo := a.heap2Lo
 		a.heap2Lo += n
-		return lo
+		return lo, nil
 	}
 	return a.allocUnitsRare(i)
 }
 
-func (a *subAllocator) newContext(s state, suffix context) context {
+func (a *subAllocator) newContext(s state, suffix context) (context, error) {
 	var n int32
 	if a.heap2Lo < a.heap2Hi {
 		// allocate from top of heap2
 		a.heap2Hi -= 2
 		n = a.heap2Hi
 	} else if n = a.removeFreeBlock(1); n == 0 {
-		if n = a.allocUnitsRare(1); n == 0 {
-			return 0
+		var err error
+		if n, err = a.allocUnitsRare(1); err != nil {
+			return 0, err
 		}
 	}
 	// we don't need to set numStates to 1 as the default value of 0 in the sym
 	// field is always incremented by 1 to get numStates.
 	a.states[n] = state{succ: int32(suffix)}
 	a.states[n+1] = s
-	return context(n)
+	return context(n), nil
 }
 
-func (a *subAllocator) newContextSize(ns int) context {
-	c := a.newContext(state{}, context(0))
+// newContextSize returns a new context with ns states, or errPPMNoMemory
+// if there isn't enough free memory.
+func (a *subAllocator) newContextSize(ns int) (context, error) {
+	c, err := a.newContext(state{}, context(0))
+	if err != nil {
+		return 0, err
+	}
 	a.contextSetNumStates(c, ns)
 	i := units2Index[(ns+1)>>1]
-	n := a.allocUnits(i)
+	n, err := a.allocUnits(i)
+	if err != nil {
+		return 0, err
+	}
 	a.contextSetStatesIndex(c, n)
-	return c
+	return c, nil
 }
 
 // since number of states is always > 0 && <= 256, we can fit it in a single byte
@@ -500,14 +538,14 @@ func (a *subAllocator) shrinkStates(c context, states []state, size int) []state
 }
 
 // expandStates expands the states list by one
-func (a *subAllocator) expandStates(c context) []state {
+func (a *subAllocator) expandStates(c context) ([]state, error) {
 	states := a.contextStates(c)
 	ns := len(states)
 	if ns == 1 {
 		s := states[0]
-		n := a.allocUnits(1)
-		if n == 0 {
-			return nil
+		n, err := a.allocUnits(1)
+		if err != nil {
+			return nil, err
 		}
 		a.contextSetStatesIndex(c, n)
 		states = a.states[n:]
@@ -517,9 +555,9 @@ func (a *subAllocator) expandStates(c context) []state {
 		i1 := units2Index[u]
 		i2 := units2Index[u+1]
 		if i1 != i2 {
-			n := a.allocUnits(i2)
-			if n == 0 {
-				return nil
+			n, err := a.allocUnits(i2)
+			if err != nil {
+				return nil, err
 			}
 			copy(a.states[n:], states)
 			a.addFreeBlock(a.contextStatesIndex(c), i1)
@@ -528,7 +566,7 @@ func (a *subAllocator) expandStates(c context) []state {
 		}
 	}
 	a.contextSetNumStates(c, ns+1)
-	return states[:ns+1]
+	return states[:ns+1], nil
 }
 
 func (a *subAllocator) findState(c context, sym byte) *state {
@@ -561,7 +599,9 @@ type model struct {
 	sbuf        [256]*state
 }
 
-func (m *model) restart() {
+// restart resets the model to its initial state. It returns ErrCorruptPPM if
+// there isn't enough memory to create the initial context.
+func (m *model) restart() error {
 	clear(m.charMask[:])
 	m.escCount = 1
 
@@ -574,9 +614,14 @@ func (m *model) restart() {
 	m.runLength = m.initRL
 	m.prevSuccess = 0
 
-	m.a.restart()
-
-	m.c = m.a.newContextSize(256)
+	if err := m.a.restart(); err != nil {
+		return err
+	}
+	var err error
+	m.c, err = m.a.newContextSize(256)
+	if err != nil {
+		return ErrCorruptPPM
+	}
 	m.a.contextSetSummFreq(m.c, 257)
 	states := m.a.contextStates(m.c)
 	for i := range states {
@@ -598,6 +643,14 @@ func (m *model) restart() {
 			m.see2Cont[i][j] = see
 		}
 	}
+	return nil
+}
+
+// release returns the model's memory to the pool. The model must be
+// initialized again before more symbols can be decoded.
+func (m *model) release() {
+	m.a.release()
+	m.c = 0
 }
 
 func (m *model) init(br io.ByteReader, reset bool, maxOrder, maxMB int) error {
@@ -609,8 +662,9 @@ func (m *model) init(br io.ByteReader, reset bool, maxOrder, maxMB int) error {
 		return nil
 	}
 
-	m.a.init(maxMB)
-
+	if err = m.a.init(maxMB); err != nil {
+		return err
+	}
 	if maxOrder == 1 {
 		return ErrCorruptPPM
 	}
@@ -687,10 +741,17 @@ func (m *model) decodeBinSymbol(c context) (*state, error) {
 	if s.sym >= 64 {
 		i += 2 * 8
 	}
+	if s.freq == 0 || int(s.freq) > len(m.binSumm) {
+		return nil, ErrCorruptPPM
+	}
 	bs := &m.binSumm[s.freq-1][i]
 	mean := (*bs + 1<<(periodBits-2)) >> periodBits
 
-	if m.rc.currentCount(binScale) < uint32(*bs) {
+	count, err := m.rc.currentCount(binScale)
+	if err != nil {
+		return nil, err
+	}
+	if count < uint32(*bs) {
 		err := m.rc.decode(0, uint32(*bs))
 		if s.freq < 128 {
 			s.freq++
@@ -700,8 +761,11 @@ func (m *model) decodeBinSymbol(c context) (*state, error) {
 		m.runLength++
 		return s, err
 	}
-	err := m.rc.decode(uint32(*bs), binScale)
+	err = m.rc.decode(uint32(*bs), binScale)
 	*bs -= mean
+	if int(*bs>>10) >= len(expEscape) {
+		return nil, ErrCorruptPPM
+	}
 	m.initEsc = expEscape[*bs>>10]
 	m.charMask[s.sym] = m.escCount
 	m.prevSuccess = 0
@@ -716,7 +780,10 @@ func (m *model) decodeSymbol1(c context) (*state, error) {
 	if scale == 0 {
 		return nil, ErrCorruptPPM
 	}
-	count := m.rc.currentCount(scale)
+	count, err := m.rc.currentCount(scale)
+	if err != nil {
+		return nil, err
+	}
 	m.prevSuccess = 0
 
 	var n uint32
@@ -774,6 +841,9 @@ func (m *model) makeEscFreq(c context, numMasked int) *see2Context {
 }
 
 func (m *model) decodeSymbol2(c context, numMasked int) (*state, error) {
+	if m.a.contextNumStates(c) <= numMasked {
+		return nil, ErrCorruptPPM
+	}
 	see := m.makeEscFreq(c, numMasked)
 	scale := see.mean()
 
@@ -783,17 +853,22 @@ func (m *model) decodeSymbol2(c context, numMasked int) (*state, error) {
 	n := len(states) - numMasked
 	sl := m.ibuf[:n]
 	for j := range sl {
-		for m.charMask[states[i].sym] == m.escCount {
+		for i < len(states) && m.charMask[states[i].sym] == m.escCount {
 			i++
 		}
+		if i == len(states) {
+			return nil, ErrCorruptPPM
+		}
 		hi += uint32(states[i].freq)
 		sl[j] = i
 		i++
 	}
 
 	scale += hi
-	count := m.rc.currentCount(scale)
-
+	count, err := m.rc.currentCount(scale)
+	if err != nil {
+		return nil, err
+	}
 	if count >= scale {
 		return nil, ErrCorruptPPM
 	}
@@ -816,7 +891,7 @@ func (m *model) decodeSymbol2(c context, numMasked int) (*state, error) {
 	}
 	s := &states[sl[n]]
 
-	err := m.rc.decode(hi-uint32(s.freq), hi)
+	err = m.rc.decode(hi-uint32(s.freq), hi)
 
 	see.update()
 
@@ -828,7 +903,7 @@ func (m *model) decodeSymbol2(c context, numMasked int) (*state, error) {
 	return m.rescale(c, s), err
 }
 
-func (m *model) createSuccessors(c context, s, ss *state) context {
+func (m *model) createSuccessors(c context, s, ss *state) (context, error) {
 	sl := m.sbuf[:0]
 
 	if m.orderFall != 0 {
@@ -850,7 +925,7 @@ func (m *model) createSuccessors(c context, s, ss *state) context {
 	}
 
 	if len(sl) == 0 {
-		return c
+		return c, nil
 	}
 
 	var up state
@@ -878,19 +953,22 @@ func (m *model) createSuccessors(c context, s, ss *state) context {
 	}
 
 	for i := len(sl) - 1; i >= 0; i-- {
-		c = m.a.newContext(up, c)
-		if c == 0 {
-			return c
+		var err error
+		c, err = m.a.newContext(up, c)
+		if err != nil {
+			return 0, err
 		}
 		sl[i].succ = int32(c)
 	}
-	return c
+	return c, nil
 }
 
-func (m *model) update(minC, maxC context, s *state) context {
+// update updates the model after symbol s was decoded and returns the next
+// context. It returns errPPMNoMemory if the model needs to be restarted.
+func (m *model) update(minC, maxC context, s *state) (context, error) {
 	if m.orderFall == 0 {
 		if s.succ > 0 {
-			return context(s.succ)
+			return context(s.succ), nil
 		}
 	}
 
@@ -909,6 +987,9 @@ func (m *model) update(minC, maxC context, s *state) context {
 		if len(states) > 1 {
 			for states[i].sym != s.sym {
 				i++
+				if i == len(states) {
+					return 0, ErrCorruptPPM // symbol missing from suffix
+				}
 			}
 			if i > 0 && states[i].freq >= states[i-1].freq {
 				states[i-1], states[i] = states[i], states[i-1]
@@ -925,14 +1006,15 @@ func (m *model) update(minC, maxC context, s *state) context {
 	}
 
 	if m.orderFall == 0 {
-		minC = m.createSuccessors(minC, s, ss)
+		var err error
+		minC, err = m.createSuccessors(minC, s, ss)
 		s.succ = int32(minC)
-		return minC
+		return minC, err
 	}
 
-	succ := m.a.pushByte(s.sym)
-	if succ == 0 {
-		return context(0)
+	succ, err := m.a.pushByte(s.sym)
+	if err != nil {
+		return 0, err
 	}
 
 	var newC context
@@ -943,9 +1025,9 @@ func (m *model) update(minC, maxC context, s *state) context {
 		if s.succ > 0 {
 			newC = context(s.succ)
 		} else {
-			newC = m.createSuccessors(minC, s, ss)
-			if newC == 0 {
-				return context(0)
+			newC, err = m.createSuccessors(minC, s, ss)
+			if err != nil {
+				return 0, err
 			}
 		}
 		m.orderFall--
@@ -962,9 +1044,9 @@ func (m *model) update(minC, maxC context, s *state) context {
 	for c := maxC; c != minC; c = m.a.contextSuffix(c) {
 		var summFreq uint16
 
-		states := m.a.expandStates(c)
-		if states == nil {
-			return context(0)
+		states, err := m.a.expandStates(c)
+		if err != nil {
+			return 0, err
 		}
 		if ns := len(states) - 1; ns != 1 {
 			summFreq = m.a.contextSummFreq(c)
@@ -1016,12 +1098,14 @@ func (m *model) update(minC, maxC context, s *state) context {
 		states[len(states)-1] = state{sym: s.sym, freq: freq, succ: succ}
 		m.a.contextSetSummFreq(c, summFreq)
 	}
-	return newC
+	return newC, nil
 }
 
 func (m *model) ReadByte() (byte, error) {
 	if m.c == 0 {
-		m.restart()
+		if err := m.restart(); err != nil {
+			return 0, err
+		}
 	}
 	minC := m.c
 	maxC := minC
@@ -1047,7 +1131,13 @@ func (m *model) ReadByte() (byte, error) {
 		return 0, err
 	}
 
-	m.c = m.update(minC, maxC, s)
+	m.c, err = m.update(minC, maxC, s)
+	if err == errPPMNoMemory {
+		// out of memory, restart the model before decoding the next symbol
+		m.c = 0
+	} else if err != nil {
+		return 0, err
+	}
 	m.prevSym = s.sym
 	return s.sym, nil
 }
@@ -0,0 +1,256 @@
+package rardecode
+
+import "hash"
+
+const aheadBufSize = 0x10000 // maximum size of a buffer filled by aheadReader
+
+// ReadAhead makes a Reader decode up to n bytes of the current file ahead of
+// Read and WriteTo calls in a separate goroutine, so a file is decompressed
+// while the caller is still processing the previous data. The goroutine is
+// stopped when the end of the file is reached or the Reader advances to another
+// file, is Reset or Closed. A Reader abandoned in the middle of a file leaves
+// its goroutine blocked forever, so callers must read to the end of the file or
+// call Next, Skip or Close before discarding it. A Reader from NewReader has no
+// Close method, so it must be read to the end of the file, or advanced with Next
+// or Skip. Changes the goroutine makes to the header of the file being read,
+// such as VolumeSpans and Checksum, and calls to the VolumeChanged function,
+// are made by the Read and WriteTo calls that return the data following them.
+// Methods reporting archive state, such as IsVolume, must not be called while
+// a file is being read. The default of 0 disables reading ahead.
+func ReadAhead(n int) Option {
+	return func(o *option) { o.ahead = n }
+}
+
+// aheadBuf is data read by the aheadReader goroutine and the error that
+// followed it.
+type aheadBuf struct {
+	b   []byte
+	err error
+	fns []func() // changes queued by volume.later before b was returned
+}
+
+// aheadReader is a byteReader that reads r in a separate goroutine, copying
+// the data into a fixed number of buffers that are recycled as they are used.
+type aheadReader struct {
+	c    chan aheadBuf // buffers filled by the goroutine
+	free chan []byte   // buffers available to be filled
+	done chan struct{} // closed to stop the goroutine
+	exit chan struct{} // closed when the goroutine has returned
+	buf  []byte        // buffer last returned by bytes, returned to free on the next call
+	b    []byte        // unread data
+	err  error         // error to return when b is empty
+	v    *volume       // volume read by the goroutine
+}
+
+func newAheadReader(r byteReader, n int, v *volume) *aheadReader {
+	size := min(n, aheadBufSize)
+	count := (n+size-1)/size + 1 // one extra buffer is held by the reader
+	a := &aheadReader{
+		c:    make(chan aheadBuf, count),
+		free: make(chan []byte, count),
+		done: make(chan struct{}),
+		exit: make(chan struct{}),
+		v:    v,
+	}
+	for i := 0; i < count; i++ {
+		a.free <- nil
+	}
+	v.async = true
+	go a.run(r, size)
+	return a
+}
+
+// run reads r until an error is returned or the reader is stopped.
+func (a *aheadReader) run(r byteReader, size int) {
+	defer close(a.exit)
+	var rem []byte // data returned by r that hasn't been copied to a buffer
+	var err error
+	for {
+		var buf []byte
+		select {
+		case buf = <-a.free:
+		case <-a.done:
+			return
+		}
+		if cap(buf) < size {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+		n := 0
+		for n < len(buf) {
+			if len(rem) == 0 {
+				if err != nil {
+					break
+				}
+				rem, err = r.bytes()
+				continue
+			}
+			k := copy(buf[n:], rem)
+			rem = rem[k:]
+			n += k
+		}
+		ab := aheadBuf{b: buf[:n], fns: a.v.queue}
+		a.v.queue = nil
+		if len(rem) == 0 {
+			ab.err = err
+		}
+		select {
+		case a.c <- ab:
+		case <-a.done:
+			return
+		}
+		if ab.err != nil {
+			return
+		}
+	}
+}
+
+// stop stops the goroutine and waits for it to return, after which the
+// underlying reader may be used again. The changes queued with data that
+// wasn't read are made, as the goroutine has moved past them.
+func (a *aheadReader) stop() {
+	select {
+	case <-a.done:
+	default:
+		close(a.done)
+	}
+	<-a.exit
+	for {
+		select {
+		case ab := <-a.c:
+			runAll(ab.fns)
+			continue
+		default:
+		}
+		break
+	}
+	a.v.async = false
+	runAll(a.v.queue)
+	a.v.queue = nil
+}
+
+func (a *aheadReader) fill() {
+	if a.buf != nil {
+		a.free <- a.buf
+		a.buf = nil
+	}
+	ab := <-a.c
+	runAll(ab.fns)
+	a.buf, a.b, a.err = ab.b, ab.b, ab.err
+}
+
+// runAll calls each function in fns.
+func runAll(fns []func()) {
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+func (a *aheadReader) bytes() ([]byte, error) {
+	for len(a.b) == 0 {
+		if a.err != nil {
+			return nil, a.err
+		}
+		a.fill()
+	}
+	b := a.b
+	a.b = nil
+	return b, nil
+}
+
+func (a *aheadReader) Read(p []byte) (int, error) {
+	for len(a.b) == 0 {
+		if a.err != nil {
+			return 0, a.err
+		}
+		a.fill()
+	}
+	n := copy(p, a.b)
+	a.b = a.b[n:]
+	return n, nil
+}
+
+// BackgroundChecksum makes a Reader calculate file checksums in a separate
+// goroutine, with up to n bytes of data copied and queued to be hashed, so that
+// hashing overlaps with decompression and decryption. This mostly benefits
+// RAR 5 archives, where the BLAKE2sp checksums and the SHA-256 HMACs of
+// encrypted files can take a large part of the time to read a file. The
+// goroutine is stopped like a ReadAhead goroutine, and only runs while a file
+// with a checksum is read. The default of 0 hashes data as it is read.
+func BackgroundChecksum(n int) Option {
+	return func(o *option) { o.bgSum = n }
+}
+
+// hashWorker writes data to a hash in a separate goroutine. Data is copied
+// into a fixed number of buffers that are recycled once hashed, as the slices
+// returned by readers are only valid until the next read.
+type hashWorker struct {
+	c      chan []byte   // buffers to hash, closed when no more will be sent
+	free   chan []byte   // buffers available to be filled
+	exit   chan struct{} // closed when the goroutine has returned
+	quit   chan struct{} // closed to stop hashing queued buffers
+	size   int           // size of each buffer
+	closed bool          // c has been closed
+}
+
+func newHashWorker(h hash.Hash, n int) *hashWorker {
+	size := min(n, aheadBufSize)
+	count := (n + size - 1) / size
+	w := &hashWorker{
+		c:    make(chan []byte, count),
+		free: make(chan []byte, count),
+		exit: make(chan struct{}),
+		quit: make(chan struct{}),
+		size: size,
+	}
+	for i := 0; i < count; i++ {
+		w.free <- nil
+	}
+	go w.run(h)
+	return w
+}
+
+// run hashes buffers until c is closed, or discards them once quit is closed.
+func (w *hashWorker) run(h hash.Hash) {
+	defer close(w.exit)
+	for b := range w.c {
+		select {
+		case <-w.quit:
+		default:
+			_, _ = h.Write(b) // ignore error, should always succeed
+		}
+		w.free <- b
+	}
+}
+
+// write queues a copy of b to be hashed.
+func (w *hashWorker) write(b []byte) {
+	for len(b) > 0 {
+		buf := <-w.free
+		if cap(buf) < w.size {
+			buf = make([]byte, w.size)
+		}
+		n := copy(buf[:w.size], b)
+		w.c <- buf[:n]
+		b = b[n:]
+	}
+}
+
+// stop waits for the queued data to be hashed and the goroutine to return,
+// after which the hash may be used.
+func (w *hashWorker) stop() {
+	if !w.closed {
+		close(w.c)
+		w.closed = true
+	}
+	<-w.exit
+}
+
+// cancel is like stop, but discards the queued data instead of hashing it,
+// for files that weren't read to the end. The hash is left incomplete.
+func (w *hashWorker) cancel() {
+	if !w.closed {
+		close(w.quit)
+	}
+	w.stop()
+}
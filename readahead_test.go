@@ -0,0 +1,158 @@
+package rardecode
+
+import (
+	"bytes"
+	"hash"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+// testData returns n bytes of compressible text seeded by seed.
+func testData(n int, seed byte) []byte {
+	words := []string{"the ", "archive ", "volume ", "header ", "block ", "data ", "file ", "\n"}
+	b := make([]byte, 0, n+8)
+	x := uint32(seed) + 1
+	for len(b) < n {
+		x = x*1664525 + 1013904223
+		b = append(b, words[x>>29]...)
+		if x>>24&0xf == 0 {
+			b = append(b, byte(x>>8))
+		}
+	}
+	return b[:n]
+}
+
+func TestReadAheadSolidSkip(t *testing.T) {
+	var blocks [][]byte
+	var files [][]byte
+	for i := 0; i < 5; i++ {
+		data := testData(20000+i*1000, byte(i))
+		files = append(files, data)
+		blocks = append(blocks, compressedFile50(string(rune('a'+i)), data, i > 0))
+	}
+	arc := testArchive50(0x4, blocks...) // solid archive
+	for _, ahead := range []int{0, 1, 4096} {
+		r, err := NewReader(bytes.NewReader(arc), ReadAhead(ahead))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range files {
+			if _, err = r.Next(); err != nil {
+				t.Fatalf("ReadAhead(%d): file %d: %v", ahead, i, err)
+			}
+			// only read every third file, so Next skips the rest
+			if i%3 != 2 {
+				continue
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAhead(%d): file %d: %v", ahead, i, err)
+			}
+			if !bytes.Equal(b, files[i]) {
+				t.Fatalf("ReadAhead(%d): file %d: data mismatch", ahead, i)
+			}
+		}
+		if _, err = r.Next(); err != io.EOF {
+			t.Fatalf("ReadAhead(%d): got %v, want io.EOF", ahead, err)
+		}
+	}
+}
+
+func TestReadAheadVolumes(t *testing.T) {
+	parts := [][]byte{testData(5000, 1), testData(3000, 2), testData(7000, 3)}
+	fsys := splitArchive50("f", parts...)
+	var changes []VolumeChange
+	opts := []Option{
+		FileSystem(fsys),
+		ReadAhead(1024),
+		VolumeChanged(func(c *VolumeChange) { changes = append(changes, *c) }),
+	}
+	rc, err := OpenReader("v.part1.rar", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	h, err := rc.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b []byte
+	buf := make([]byte, 100)
+	for {
+		n, err := rc.Read(buf)
+		b = append(b, buf[:n]...)
+		// header fields and volume changes are updated by Read, so they
+		// can be checked between calls
+		if len(h.VolumeSpans) != len(changes)+1 {
+			t.Fatalf("got %d spans after %d volume changes", len(h.VolumeSpans), len(changes))
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(b, bytes.Join(parts, nil)) {
+		t.Fatal("data mismatch")
+	}
+	if len(changes) != len(parts)-1 {
+		t.Fatalf("got %d volume changes, want %d", len(changes), len(parts)-1)
+	}
+	for i, c := range changes {
+		if c.Volume != i+1 {
+			t.Errorf("volume change %d: got volume %d", i, c.Volume)
+		}
+	}
+	if len(h.Checksum) == 0 {
+		t.Error("checksum not set after reading file")
+	}
+}
+
+// blockingHash is a hash whose first Write closes started, then waits until
+// release is closed.
+type blockingHash struct {
+	hash.Hash32
+	writes  int
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHash) Write(b []byte) (int, error) {
+	if h.writes == 0 {
+		close(h.started)
+		<-h.release
+	}
+	h.writes++
+	return h.Hash32.Write(b)
+}
+
+func TestHashWorkerCancel(t *testing.T) {
+	data := testData(3*aheadBufSize, 5)
+	for _, cancel := range []bool{false, true} {
+		h := &blockingHash{Hash32: crc32.NewIEEE(), started: make(chan struct{}), release: make(chan struct{})}
+		w := newHashWorker(h, len(data))
+		w.write(data)
+		// the first buffer is being hashed while the rest are queued
+		<-h.started
+		done := make(chan struct{})
+		go func() {
+			if cancel {
+				w.cancel()
+			} else {
+				w.stop()
+			}
+			close(done)
+		}()
+		if cancel {
+			<-w.quit
+		}
+		close(h.release)
+		<-done
+		if cancel && h.writes != 1 {
+			t.Errorf("cancel: got %d writes, want 1", h.writes)
+		} else if !cancel && h.Sum32() != crc32.ChecksumIEEE(data) {
+			t.Errorf("stop: got %d writes and the wrong checksum", h.writes)
+		}
+	}
+}
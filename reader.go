@@ -8,8 +8,8 @@ import (
 	"errors"
 	"hash"
 	"io"
+	"io/fs"
 	"math"
-	"os"
 	"time"
 )
 
@@ -24,6 +24,40 @@ const (
 	HostOSBeOS    = 6
 )
 
+// FileHeader RedirectType types
+const (
+	RedirectNone            = 0
+	RedirectUnixSymlink     = 1
+	RedirectWindowsSymlink  = 2
+	RedirectWindowsJunction = 3
+	RedirectHardLink        = 4
+	RedirectFileCopy        = 5
+)
+
+// FileHeader StoredTimes flags
+const (
+	TimeModification = 1 << iota // ModificationTime is stored in the archive
+	TimeCreation                 // CreationTime is stored in the archive
+	TimeAccess                   // AccessTime is stored in the archive
+)
+
+// FileHeader ChecksumType values
+const (
+	ChecksumNone     = 0
+	ChecksumCRC32    = 1
+	ChecksumBLAKE2sp = 2
+)
+
+// FileHeader Method types
+const (
+	MethodStore   = 0
+	MethodFastest = 1
+	MethodFast    = 2
+	MethodNormal  = 3
+	MethodGood    = 4
+	MethodBest    = 5
+)
+
 const (
 	maxPassword = int(128)
 )
@@ -33,8 +67,14 @@ var (
 	ErrInvalidFileBlock = errors.New("rardecode: invalid file block")
 	ErrUnexpectedArcEnd = errors.New("rardecode: unexpected end of archive")
 	ErrBadFileChecksum  = errors.New("rardecode: bad file checksum")
+	ErrNoStreamData     = errors.New("rardecode: stream data can't be opened")
 	ErrSolidOpen        = errors.New("rardecode: solid files don't support Open")
 	ErrUnknownVersion   = errors.New("rardecode: unknown archive version")
+	ErrSolidSkipped     = errors.New("rardecode: solid file can't be read after skipping a previous file")
+	ErrInvalidSeek      = errors.New("rardecode: invalid seek")
+	ErrNegativeCount    = errors.New("rardecode: negative count")
+	ErrLimitsExceeded   = errors.New("rardecode: archive exceeds configured limits")
+	ErrStaleReader      = errors.New("rardecode: file reader no longer valid")
 )
 
 // FileHeader represents a single file in a RAR archive.
@@ -42,25 +82,118 @@ type FileHeader struct {
 	Name             string    // file name using '/' as the directory separator
 	IsDir            bool      // is a directory
 	Solid            bool      // is a solid file
+	SolidChainIndex  int       // position in the chain of files sharing decode state (0 starts a new chain)
 	Encrypted        bool      // file contents are encrypted
 	HeaderEncrypted  bool      // file header is encrypted
 	HostOS           byte      // Host OS the archive was created on
 	Attributes       int64     // Host OS specific file attributes
 	PackedSize       int64     // packed file size (or first block if the file spans volumes)
+	HeaderOffset     int64     // offset of the file's first block header in the volume containing it
+	DataOffset       int64     // offset of the packed data following the first block header
+	SplitBefore      bool      // file data continues from the previous volume
+	SplitAfter       bool      // file data continues in the next volume
 	UnPackedSize     int64     // unpacked file size
 	UnKnownSize      bool      // unpacked file size is not known
 	ModificationTime time.Time // modification time (non-zero if set)
 	CreationTime     time.Time // creation time (non-zero if set)
 	AccessTime       time.Time // access time (non-zero if set)
+	StoredTimes      int       // times stored in the archive, a combination of the Time flags
 	Version          int       // file version
+	RedirectType     int       // redirection type for links and file copies (RAR 5 only)
+	RedirectTarget   string    // redirection target name (non-empty if RedirectType is set)
+	Owner            string    // unix owner user name (non-empty if set)
+	Group            string    // unix owner group name (non-empty if set)
+	UID              int       // unix numeric user id (-1 if not set, RAR 5 only)
+	GID              int       // unix numeric group id (-1 if not set, RAR 5 only)
+
+	// Method is the compression method, MethodStore to MethodBest.
+	// CompressionVersion is the RAR version of the compression algorithm
+	// (eg. 20, 29 for RAR 1.5 format archives, 50 or 70 for RAR 5).
+	// DictionarySize is the size of the decode window needed to extract the file.
+	// CompressionVersion and DictionarySize are zero for stored files.
+	Method             int
+	CompressionVersion int
+	DictionarySize     int64
+
+	// Checksum is the expected checksum of the file's unpacked contents as stored
+	// in the archive, using the algorithm given by ChecksumType. CRC32 checksums
+	// are little endian. Only CRC32 checksums are verified when the file is read.
+	// If a RAR 5 file is encrypted with a password that includes a hash key, the
+	// stored value is a MAC of the checksum rather than the checksum itself.
+	// The checksum is stored with the last block of a file spanning volumes, so
+	// from a Reader it is only set once that block has been read.
+	ChecksumType int
+	Checksum     []byte
+
+	// PasswordIndex is the index in the list set by the Passwords option of the
+	// password used to decrypt the file, or -1 if the file isn't encrypted or
+	// the password didn't come from the list. Keys are only generated when the
+	// file is read, so from a Reader it is set once reading has started.
+	PasswordIndex int
+
+	// ExtendedAttrs contains extended attribute and security data for the file,
+	// keyed by the service block name ("ACL" for NTFS security descriptors,
+	// "EA2" for OS/2 and "EABE" for BeOS extended attributes).
+	// It is stored after the file data, so it is only available from a Reader
+	// after Next has been called for the following file.
+	// The same applies to Owner and Group in RAR 1.5 format archives.
+	ExtendedAttrs map[string][]byte
+
+	// VolumeSpans lists where the packed data for the file is stored, one entry
+	// for each volume the file spans. It is complete for files returned by List,
+	// but from a Reader it only contains the volumes read so far.
+	VolumeSpans []VolumeSpan
+
+	// Streams lists the NTFS alternate data streams stored for the file. Like
+	// ExtendedAttrs, they follow the file data, so are only available from a
+	// Reader after Next has been called for the following file.
+	Streams []StreamHeader
+
+	raw FileSys // raw header values returned by FileInfo, except HostOS and Attributes
+}
+
+// StreamHeader describes an NTFS alternate data stream stored for a file.
+type StreamHeader struct {
+	Name         string // stream name as stored in the archive, eg. ":Zone.Identifier"
+	PackedSize   int64  // packed stream size
+	UnPackedSize int64  // unpacked stream size
+	Encrypted    bool   // stream data is encrypted
+
+	pr *packedFileReader // reader for the stream data, nil if it can't be opened
+}
+
+// Open returns an io.ReadCloser that reads the unpacked stream data. The archive
+// is opened again to read it, so it isn't available for archives read by
+// NewReader, or for streams split across volumes.
+func (s *StreamHeader) Open() (io.ReadCloser, error) {
+	if s.pr == nil {
+		return nil, ErrNoStreamData
+	}
+	rc := new(ReadCloser)
+	rc.pr = s.pr.clone()
+	if err := rc.pr.init(); err != nil {
+		return nil, err
+	}
+	return rc, nil
 }
 
-// Mode returns an os.FileMode for the file, calculated from the Attributes field.
-func (f *FileHeader) Mode() os.FileMode {
-	var m os.FileMode
+// VolumeSpan is the location of part of a file's packed data.
+type VolumeSpan struct {
+	VolumeIndex  int   // volume number, starting at 0
+	Offset       int64 // offset of the packed data from the start of the volume
+	PackedLength int64 // number of bytes of packed data in the volume
+}
+
+// Mode returns an fs.FileMode for the file, calculated from the Attributes field.
+func (f *FileHeader) Mode() fs.FileMode {
+	var m fs.FileMode
 
 	if f.IsDir {
-		m = os.ModeDir
+		m = fs.ModeDir
+	}
+	switch f.RedirectType {
+	case RedirectUnixSymlink, RedirectWindowsSymlink, RedirectWindowsJunction:
+		m |= fs.ModeSymlink
 	}
 	if f.HostOS == HostOSWindows {
 		if f.IsDir {
@@ -73,7 +206,7 @@ func (f *FileHeader) Mode() os.FileMode {
 		return m
 	}
 	// assume unix perms for all remaining os types
-	m |= os.FileMode(f.Attributes) & os.ModePerm
+	m |= fs.FileMode(f.Attributes) & fs.ModePerm
 
 	// only check other bits on unix host created archives
 	if f.HostOS != HostOSUnix {
@@ -81,18 +214,18 @@ func (f *FileHeader) Mode() os.FileMode {
 	}
 
 	if f.Attributes&0x200 != 0 {
-		m |= os.ModeSticky
+		m |= fs.ModeSticky
 	}
 	if f.Attributes&0x400 != 0 {
-		m |= os.ModeSetgid
+		m |= fs.ModeSetgid
 	}
 	if f.Attributes&0x800 != 0 {
-		m |= os.ModeSetuid
+		m |= fs.ModeSetuid
 	}
 
 	// Check for additional file types.
 	if f.Attributes&0xF000 == 0xA000 {
-		m |= os.ModeSymlink
+		m |= fs.ModeSymlink
 	}
 	return m
 }
@@ -130,17 +263,23 @@ func newBufByteReader(buf []byte) *bufByteReader {
 
 // packedFileReader provides sequential access to packed files in a RAR archive.
 type packedFileReader struct {
-	n int64 // bytes left in current data block
-	v *volume
-	r fileBlockReader
-	h *fileBlockHeader // current file header
+	n     int64 // bytes left in current data block
+	read  int64 // bytes of packed data read from the current file
+	v     *volume
+	r     fileBlockReader
+	h     *fileBlockHeader // current file header
+	chain int              // SolidChainIndex of the next file if it is solid
+	count int              // number of files read
+	file  *FileHeader      // header of the first block of the current file
+	pos   Position         // position of the current file
+	start *Position        // position reading was resumed at, nil if not resumed
 }
 
 // init initializes a cloned packedFileReader
 func (f *packedFileReader) init() error { return f.v.init() }
 
 func (f *packedFileReader) clone() *packedFileReader {
-	nr := &packedFileReader{n: f.n, h: f.h}
+	nr := &packedFileReader{n: f.n, h: f.h, pos: f.pos}
 	nr.r = f.r.clone()
 	nr.v = f.v.clone()
 	return nr
@@ -173,16 +312,44 @@ func (f *packedFileReader) nextBlock() error {
 		}
 		return err
 	}
+	f.normalizeName(h)
 	if h.first || h.Name != f.h.Name {
 		return ErrInvalidFileBlock
 	}
 	f.n = h.PackedSize
 	f.h = h
+	f.addSpan()
+	if file := f.file; h.last && file != nil {
+		f.v.later(func() { file.ChecksumType, file.Checksum = h.ChecksumType, h.Checksum })
+	}
 	return nil
 }
 
+// normalizeName applies the NormalizeNames option to the name of h.
+func (f *packedFileReader) normalizeName(h *fileBlockHeader) {
+	if fn := f.v.opt.normNm; fn != nil {
+		h.Name = fn(h.Name)
+	}
+}
+
+// addSpan records the location of the current block's data in the file's header.
+// Cloned readers don't have the header, as the spans have already been recorded.
+func (f *packedFileReader) addSpan() {
+	if f.file == nil {
+		return
+	}
+	file := f.file
+	s := VolumeSpan{VolumeIndex: f.v.num, Offset: f.v.off, PackedLength: f.h.PackedSize}
+	f.v.later(func() { file.VolumeSpans = append(file.VolumeSpans, s) })
+}
+
 // next advances to the next packed file in the RAR archive.
 func (f *packedFileReader) next() (*fileBlockHeader, error) {
+	if f.v.end {
+		// don't read any data following the end of the archive
+		f.h = nil
+		return nil, io.EOF
+	}
 	// skip to last block in current file
 	var err error
 	for err == nil {
@@ -195,13 +362,89 @@ func (f *packedFileReader) next() (*fileBlockHeader, error) {
 	if err != nil {
 		return nil, err
 	}
+	if max := f.v.opt.maxFiles; max > 0 && f.count >= max {
+		return nil, ErrLimitsExceeded
+	}
+	if f.skipToStart() && f.v.seekable() {
+		// seek straight to the block header reading is resumed at
+		if err = f.v.seek(f.start.Offset); err != nil {
+			return nil, err
+		}
+		if f.h, err = f.r.next(f.v); err != nil {
+			return nil, err
+		}
+	}
+	for (!f.h.first && f.count == 0 && f.v.mid) || f.skipToStart() {
+		// file started in a volume before the one reading began at,
+		// or precedes the position reading was resumed at, skip it
+		f.n = f.h.PackedSize
+		for err == nil {
+			err = f.nextBlock()
+		}
+		if err != io.EOF {
+			return nil, err
+		}
+		if f.h, err = f.r.next(f.v); err != nil {
+			return nil, err
+		}
+	}
 	if !f.h.first {
 		return nil, ErrInvalidFileBlock
 	}
+	if f.start != nil && f.count == 0 && (f.v.num != f.start.VolumeIndex || f.v.boff != f.start.Offset) {
+		return nil, ErrBadPosition
+	}
+	// set before the checks, so the data of a rejected file is skipped
 	f.n = f.h.PackedSize
+	f.file = nil
+	f.normalizeName(f.h)
+	if f.v.opt.check {
+		if err = checkStrict(f.h); err != nil {
+			return nil, err
+		}
+	}
+	if f.v.opt.safeNm {
+		if _, err = SanitizeName(&f.h.FileHeader); err != nil {
+			return nil, err
+		}
+	}
+	if !f.h.Solid {
+		f.chain = 0
+	}
+	f.h.SolidChainIndex = f.chain
+	f.chain++
+	f.pos = Position{Volume: f.v.file, VolumeIndex: f.v.num, Offset: f.v.boff, Files: f.count, Solid: f.h.Solid}
+	if f.start != nil {
+		f.pos.Files += f.start.Files
+	}
+	f.count++
+	f.read = 0
+	f.h.HeaderOffset, f.h.DataOffset = f.v.boff, f.v.off
+	f.file = &f.h.FileHeader
+	f.addSpan()
 	return f.h, nil
 }
 
+// skip discards the next n bytes of packed data for the current file.
+// It returns io.EOF if the end of the file is reached first.
+func (f *packedFileReader) skip(n int64) error {
+	for n > 0 {
+		for f.n == 0 {
+			if err := f.nextBlock(); err != nil {
+				return err
+			}
+		}
+		k := min(n, f.n)
+		if err := f.v.discard(k); err != nil {
+			return err
+		}
+		f.n -= k
+		f.read += k
+		n -= k
+	}
+	return nil
+}
+
 // Read reads the packed data for the current file into p.
 func (f *packedFileReader) Read(p []byte) (int, error) {
 	for f.n == 0 {
@@ -214,6 +457,7 @@ func (f *packedFileReader) Read(p []byte) (int, error) {
 	}
 	n, err := f.v.Read(p)
 	f.n -= int64(n)
+	f.read += int64(n)
 	if err == io.EOF && f.n > 0 {
 		return n, io.ErrUnexpectedEOF
 	}
@@ -233,6 +477,9 @@ func (f *packedFileReader) bytes() ([]byte, error) {
 	if k := f.v.br.Buffered(); k > 0 {
 		n = min(k, n)
 	} else {
+		if n >= f.v.br.Size() {
+			f.v.adapt(true)
+		}
 		b, err := f.v.peek(n)
 		if err != nil && err != bufio.ErrBufferFull {
 			return nil, err
@@ -241,6 +488,7 @@ func (f *packedFileReader) bytes() ([]byte, error) {
 	}
 	b, err := f.v.readSlice(n)
 	f.n -= int64(len(b))
+	f.read += int64(len(b))
 	return b, err
 }
 
@@ -256,6 +504,18 @@ func newPackedFileReader(r io.Reader, opts []Option) (*packedFileReader, error)
 	return &packedFileReader{r: fbr, v: v}, nil
 }
 
+func newPackedFileReaderAt(ra io.ReaderAt, size int64, opts []Option) (*packedFileReader, error) {
+	v, err := newVolumeAt(ra, size, opts)
+	if err != nil {
+		return nil, err
+	}
+	fbr, err := newFileBlockReader(v)
+	if err != nil {
+		return nil, err
+	}
+	return &packedFileReader{r: fbr, v: v}, nil
+}
+
 func openPackedFileReader(name string, opts []Option) (*packedFileReader, error) {
 	v, err := openVolume(name, opts)
 	if err != nil {
@@ -268,6 +528,17 @@ func openPackedFileReader(name string, opts []Option) (*packedFileReader, error)
 	return &packedFileReader{r: fbr, v: v}, nil
 }
 
+// reset reinitializes f to read from the start of its volume, which has been
+// reset to a new archive.
+func (f *packedFileReader) reset() error {
+	fbr, err := newFileBlockReader(f.v)
+	if err != nil {
+		return err
+	}
+	*f = packedFileReader{r: fbr, v: f.v}
+	return nil
+}
+
 type limitedReader struct {
 	r        byteReader
 	n        int64 // bytes remaining
@@ -290,18 +561,31 @@ func (l *limitedReader) Read(p []byte) (int, error) {
 }
 
 func (l *limitedReader) bytes() ([]byte, error) {
+	if l.n <= 0 {
+		return nil, io.EOF
+	}
 	b, err := l.r.bytes()
 	if n := len(b); int64(n) > l.n {
 		b = b[:int(l.n)]
 	}
 	l.n -= int64(len(b))
+	if err == io.EOF && l.n > 0 {
+		return b, l.shortErr
+	}
 	return b, err
 }
 
+// checksumReader is a byteReader that calculates the checksum of the data read
+// from r. Read hashes each slice returned by r whole before copying it out, so
+// the cost of hashing doesn't depend on the size of the caller's buffer. If bg
+// is set, the data is hashed by its goroutine instead.
 type checksumReader struct {
 	r    byteReader
 	hash hash.Hash
 	pr   *packedFileReader
+	bg   *hashWorker // hashes data in a separate goroutine, if enabled
+	buf  []byte      // data hashed but not yet returned by Read
+	err  error       // error to return once buf is empty
 }
 
 func (cr *checksumReader) eofError() error {
@@ -332,36 +616,96 @@ func (cr *checksumReader) eofError() error {
 }
 
 func (cr *checksumReader) Read(p []byte) (int, error) {
-	n, err := cr.r.Read(p)
-	if n > 0 {
-		if n, err = cr.hash.Write(p[:n]); err != nil {
-			return n, err
+	for len(cr.buf) == 0 {
+		if cr.err != nil {
+			return 0, cr.err
 		}
+		cr.buf, cr.err = cr.bytes()
 	}
-	if err != io.EOF {
-		return n, err
+	n := copy(p, cr.buf)
+	cr.buf = cr.buf[n:]
+	if len(cr.buf) == 0 {
+		return n, cr.err
 	}
-	return n, cr.eofError()
+	return n, nil
 }
 
 func (cr *checksumReader) bytes() ([]byte, error) {
+	if len(cr.buf) > 0 {
+		b := cr.buf
+		cr.buf = nil
+		return b, nil
+	} else if cr.err != nil {
+		return nil, cr.err
+	}
 	b, err := cr.r.bytes()
 	if len(b) > 0 {
-		if _, err = cr.hash.Write(b); err != nil {
+		if cr.bg != nil {
+			cr.bg.write(b)
+		} else if _, err = cr.hash.Write(b); err != nil {
 			return b, err
 		}
 	}
 	if err != io.EOF {
 		return b, err
 	}
+	if cr.bg != nil {
+		cr.bg.stop()
+	}
 	return b, cr.eofError()
 }
 
+// peekReader is a byteReader that returns the data buffered by Reader.Peek
+// before reading any more from r.
+type peekReader struct {
+	buf []byte // data read by Peek that hasn't been returned yet
+	err error  // error returned by r while filling buf
+	r   byteReader
+}
+
+func (p *peekReader) Read(b []byte) (int, error) {
+	if len(p.buf) == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+		return p.r.Read(b)
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+func (p *peekReader) bytes() ([]byte, error) {
+	if len(p.buf) == 0 {
+		if p.err != nil {
+			return nil, p.err
+		}
+		return p.r.bytes()
+	}
+	b := p.buf
+	p.buf = p.buf[len(b):]
+	return b, nil
+}
+
+// fill reads from r until at least n bytes are buffered or an error occurs.
+func (p *peekReader) fill(n int) {
+	for len(p.buf) < n && p.err == nil {
+		var b []byte
+		b, p.err = p.r.bytes()
+		p.buf = append(p.buf, b...)
+	}
+}
+
 // Reader provides sequential access to files in a RAR archive.
 type Reader struct {
-	r  byteReader        // reader for current unpacked file
-	dr *decodeReader     // reader for decoding and filters if file is compressed
-	pr *packedFileReader // reader for current raw file bytes
+	r       byteReader        // reader for current unpacked file
+	dr      *decodeReader     // reader for decoding and filters if file is compressed
+	pr      *packedFileReader // reader for current raw file bytes
+	ahead   *aheadReader      // reader decoding the current file ahead of reads, if enabled
+	sum     *hashWorker       // goroutine hashing the current file, if enabled
+	skipped bool              // a solid file was skipped without being decoded
+	resumed bool              // decode state before the first file was restored by ResumeSolid
+	gen     int               // incremented each time the reader advances to a new file
 }
 
 // Read reads from the current file in the RAR archive.
@@ -369,79 +713,249 @@ func (r *Reader) Read(p []byte) (int, error) {
 	if r.r == nil {
 		err := r.nextFile()
 		if err != nil {
-			return 0, err
+			return 0, r.wrapErr(err)
 		}
 	}
-	return r.r.Read(p)
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = r.wrapErr(err)
+	}
+	return n, err
+}
+
+// wrapErr returns err as an *Error with the location of the current file.
+func (r *Reader) wrapErr(err error) error {
+	var name string
+	if h := r.pr.h; h != nil {
+		name = h.Name
+	}
+	return r.pr.v.wrapErr(err, name)
 }
 
-// WriteTo implements io.WriterTo.
+// WriteTo implements io.WriterTo. Decoded data is written directly from the
+// decode window or volume buffer without being copied to an intermediate buffer.
+// It is safe to call WriteTo after reading part of the file with Read.
 func (r *Reader) WriteTo(w io.Writer) (int64, error) {
 	if r.r == nil {
 		err := r.nextFile()
 		if err != nil {
-			return 0, err
+			return 0, r.wrapErr(err)
 		}
 	}
 	var n int64
 	b, err := r.r.bytes()
 	for err == nil {
-		var nn int
-		nn, err = w.Write(b)
+		nn, werr := w.Write(b)
 		n += int64(nn)
-		if err == nil {
-			b, err = r.r.bytes()
+		if werr != nil {
+			return n, werr
 		}
+		if nn < len(b) {
+			return n, io.ErrShortWrite
+		}
+		b, err = r.r.bytes()
 	}
 	if err == io.EOF {
-		err = nil
+		return n, nil
 	}
-	return n, err
+	return n, r.wrapErr(err)
+}
+
+// Peek returns the next n bytes of the current file without advancing the reader,
+// so the file's type can be found from its contents before it is read. The bytes
+// are only valid until the next call to Read, WriteTo or Peek. If Peek returns
+// fewer than n bytes, it also returns an error explaining why, which is io.EOF
+// if the file is shorter than n bytes. ErrNegativeCount is returned if n is
+// negative.
+func (r *Reader) Peek(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, r.wrapErr(ErrNegativeCount)
+	}
+	if r.r == nil {
+		err := r.nextFile()
+		if err != nil {
+			return nil, r.wrapErr(err)
+		}
+	}
+	p, ok := r.r.(*peekReader)
+	if !ok {
+		p = &peekReader{r: r.r}
+		r.r = p
+	}
+	p.fill(n)
+	if len(p.buf) < n {
+		err := p.err
+		if err != io.EOF {
+			err = r.wrapErr(err)
+		}
+		return p.buf, err
+	}
+	return p.buf[:n], nil
 }
 
+// SFXSize returns the size of any data preceding the RAR signature in the first
+// volume of the archive, such as the executable stub of a self-extracting archive.
+func (r *Reader) SFXSize() int64 { return r.pr.v.sfx }
+
+// DamagedRegions returns the data skipped so far by the SkipDamaged option.
+func (r *Reader) DamagedRegions() []DamagedRegion { return r.pr.v.dmg }
+
 // Next advances to the next file in the archive.
 func (r *Reader) Next() (*FileHeader, error) {
+	r.stopAhead()
 	// check if file is a compressed file in a solid archive
-	if h := r.pr.h; h != nil && h.decVer > 0 && h.arcSolid {
+	if h := r.pr.h; h != nil && h.decVer > 0 && h.arcSolid && !(h.Solid && r.skipped) {
 		var err error
 		if r.r == nil {
-			// setup full file reader
-			err = r.nextFile()
+			// setup file reader, without reading ahead or hashing as r.dr is
+			// read directly
+			err = r.initFile(true)
 		}
 		// decode and discard bytes
 		for err == nil {
 			_, err = r.dr.bytes()
 		}
 		if err != io.EOF {
-			return nil, err
+			return nil, r.wrapErr(err)
 		}
 	}
+	return r.next()
+}
+
+// Skip advances to the next file in the archive without decoding the remainder
+// of the current file. Unlike Next, this also avoids decoding files in solid
+// archives, which is useful when only the file headers are needed. Solid files
+// following a skipped file can no longer be read and will return ErrSolidSkipped.
+func (r *Reader) Skip() (*FileHeader, error) {
+	if h := r.pr.h; h != nil && h.decVer > 0 && h.arcSolid {
+		r.skipped = true
+	}
+	return r.next()
+}
+
+func (r *Reader) next() (*FileHeader, error) {
+	r.stopAhead()
 	// get next packed file
 	h, err := r.pr.next()
 	if err != nil {
-		return nil, err
+		if err == io.EOF && r.dr != nil && r.dr.disk != nil {
+			// remove the disk window as a Reader may not be closed
+			r.dr.release()
+		}
+		return nil, r.pr.v.wrapErr(err, "")
+	}
+	if !h.Solid {
+		// file doesn't depend on the decode state of previous files
+		r.skipped = false
+	} else if r.pr.count == 1 && (r.pr.v.mid || r.pr.start != nil) && !r.resumed {
+		// decode state from before the first file read is unavailable
+		r.skipped = true
 	}
 	// Clear the reader as it will be setup on the next Read() or WriteTo().
 	r.r = nil
+	r.gen++
 	return &h.FileHeader, nil
 }
 
+// NextFile advances to the next file in the archive like Next, and also returns
+// a reader for the file's contents. The returned reader is only valid until the
+// next call to Next, Skip or NextFile, after which it returns ErrStaleReader.
+// Closing it does not close r, but it can no longer be read from.
+func (r *Reader) NextFile() (*FileHeader, io.ReadCloser, error) {
+	h, err := r.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, &entryReader{r: r, gen: r.gen}, nil
+}
+
+// entryReader reads the contents of the file returned by Reader.NextFile.
+type entryReader struct {
+	r      *Reader
+	gen    int  // Reader.gen when the file was returned
+	closed bool // Close has been called
+}
+
+func (er *entryReader) valid() bool { return !er.closed && er.gen == er.r.gen }
+
+func (er *entryReader) Read(p []byte) (int, error) {
+	if !er.valid() {
+		return 0, ErrStaleReader
+	}
+	return er.r.Read(p)
+}
+
+func (er *entryReader) WriteTo(w io.Writer) (int64, error) {
+	if !er.valid() {
+		return 0, ErrStaleReader
+	}
+	return er.r.WriteTo(w)
+}
+
+func (er *entryReader) Close() error {
+	er.closed = true
+	return nil
+}
+
+// nextFile sets up r.r to read the current file, reading ahead if enabled.
 func (r *Reader) nextFile() error {
+	if err := r.initFile(false); err != nil {
+		return err
+	}
+	if n := r.pr.v.opt.ahead; n > 0 && !r.pr.h.hasNoData() {
+		r.ahead = newAheadReader(r.r, n, r.pr.v)
+		r.r = r.ahead
+	}
+	return nil
+}
+
+// initFile sets up r.r to read the current file. If discard is set, the file
+// is only being decoded to be discarded, so its checksum isn't calculated.
+func (r *Reader) initFile(discard bool) error {
 	h := r.pr.h
 	if h == nil {
 		return io.EOF
 	}
+	if h.decVer > 0 {
+		if h.Solid && r.skipped {
+			return ErrSolidSkipped
+		}
+		if max := r.pr.v.opt.maxDict; max > 0 && int64(h.winSize) > max && r.pr.v.opt.winDir == nil {
+			return ErrDictionaryTooLarge
+		}
+	}
+	// hard links and file copies have no data stored in the archive
+	if h.hasNoData() {
+		r.r = newBufByteReader(nil)
+		return nil
+	}
 	// start with packed file reader
 	r.r = r.pr
 	// check for encryption
 	if h.genKeys != nil {
-		r.r = newAesDecryptReader(r.pr, h) // decrypt
+		r.r = newAesDecryptReader(r.pr, h, r.pr.v.opt.dec) // decrypt
+		if m := r.pr.v.opt.stats; m != nil {
+			r.r = &countReader{r: r.r, n: &m.DecryptedBytes}
+		}
 	}
 	// check for compression
 	if h.decVer > 0 {
 		if r.dr == nil {
 			r.dr = new(decodeReader)
 		}
+		o := r.pr.v.opt
+		r.dr.lim = decodeLimits{ppmMem: o.maxPPM, vmCmds: o.maxVMCmd, vmOut: o.maxVMOut, noVM: o.noVM}
+		r.dr.ext = o.win
+		r.dr.stats = o.stats
+		r.dr.dir = nil
+		if o.winDir != nil && o.maxDict > 0 && int64(h.winSize) > o.maxDict {
+			r.dr.dir = o.winDir
+		}
+		r.dr.fltFn = nil
+		if o.fltFn != nil {
+			fh := &h.FileHeader
+			r.dr.fltFn = func(f *FilterInfo) { o.fltFn(fh, f) }
+		}
 		err := r.dr.init(r.r, h.decVer, h.winSize, !h.Solid, h.UnPackedSize)
 		if err != nil {
 			return err
@@ -452,15 +966,35 @@ func (r *Reader) nextFile() error {
 		// Limit reading to UnPackedSize as there may be padding
 		r.r = &limitedReader{r.r, h.UnPackedSize, ErrShortFile}
 	}
-	if h.hash != nil {
-		r.r = &checksumReader{r.r, h.hash(), r.pr}
+	if h.hash != nil && !r.pr.v.opt.noSum && !discard {
+		cr := &checksumReader{r: r.r, hash: h.hash(), pr: r.pr}
+		if n := r.pr.v.opt.bgSum; n > 0 {
+			cr.bg = newHashWorker(cr.hash, n)
+			r.sum = cr.bg
+		}
+		r.r = cr
 	}
 	return nil
 }
 
+// stopAhead stops any goroutines decoding the current file ahead of reads or
+// hashing it. Data still queued to be hashed is discarded, as the checksum is
+// only needed once the file has been read to the end.
+func (r *Reader) stopAhead() {
+	if r.ahead != nil {
+		r.ahead.stop()
+		r.ahead = nil
+	}
+	if r.sum != nil {
+		r.sum.cancel()
+		r.sum = nil
+	}
+}
+
 // NewReader creates a Reader reading from r.
-// NewReader only supports single volume archives.
-// Multi-volume archives must use OpenReader.
+// NewReader only supports single volume archives, unless the VolumeProvider
+// option is used to supply the remaining volumes.
+// Multi-volume archives should otherwise use OpenReader.
 func NewReader(r io.Reader, opts ...Option) (*Reader, error) {
 	pr, err := newPackedFileReader(r, opts)
 	if err != nil {
@@ -469,32 +1003,212 @@ func NewReader(r io.Reader, opts ...Option) (*Reader, error) {
 	return &Reader{pr: pr}, nil
 }
 
+// Reset discards the Reader's state and makes it read a new archive from rd,
+// using the options the Reader was created with. Decode windows, tables and
+// buffers are kept and reused, which avoids allocating them again when many
+// archives are read in turn. Any file readers returned by NextFile are no
+// longer valid. If Reset returns an error, the Reader must not be used until
+// Reset succeeds.
+func (r *Reader) Reset(rd io.Reader) error {
+	r.stopAhead()
+	if err := r.pr.v.reset(rd); err != nil {
+		return err
+	}
+	return r.reset()
+}
+
+// reset clears the Reader's state after its volume was reset to a new archive.
+func (r *Reader) reset() error {
+	r.stopAhead()
+	r.r = nil
+	r.skipped = false
+	r.resumed = false
+	r.gen++
+	if r.dr != nil {
+		r.dr.reset()
+	}
+	return r.pr.reset()
+}
+
+// NextArchive continues reading at another archive following the end block of
+// the current one in the same file or stream, such as when archives have been
+// concatenated together. Any files remaining in the current archive are skipped.
+// It returns io.EOF if no RAR signature is found before the end of the data,
+// otherwise the next call to Next returns the first file of the new archive.
+// Data between the archives is ignored, and is reported by SFXSize. Using the
+// StrictArchiveEnd option or TrailingDataSize consumes the data following the
+// end block, after which no further archives can be found.
+func (r *Reader) NextArchive() error {
+	var err error
+	for err == nil {
+		_, err = r.Skip()
+	}
+	if err != io.EOF {
+		return err
+	}
+	r.stopAhead()
+	if err = r.pr.v.nextArchive(); err != nil {
+		if err != io.EOF {
+			err = r.pr.v.wrapErr(err, "")
+		}
+		return err
+	}
+	return r.reset()
+}
+
+// IsSolidArchive reports whether the archive is solid, so files can only be
+// decoded in order. This and the other archive flag methods report the main
+// archive header of the current volume, which is first read by Next, and
+// return false before Next has been called.
+func (r *Reader) IsSolidArchive() bool { return r.pr.v.arc.solid }
+
+// IsLocked reports whether the archive is locked against modification.
+func (r *Reader) IsLocked() bool { return r.pr.v.arc.locked }
+
+// HasRecoveryRecord reports whether the current volume has a recovery record.
+// The records of RAR 1.5 format volumes are described by RecoveryRecords.
+func (r *Reader) HasRecoveryRecord() bool { return r.pr.v.arc.recovery }
+
+// IsVolume reports whether the archive is a multi-volume archive.
+func (r *Reader) IsVolume() bool { return r.pr.v.arc.volume }
+
+// IsFirstVolume reports whether the current volume is the first volume of a
+// multi-volume archive. RAR 1.5 format archives created before RAR 3.0 don't
+// record the first volume, so it is always false for them.
+func (r *Reader) IsFirstVolume() bool { return r.pr.v.arc.first }
+
+// TrailingDataSize returns the number of bytes following the end block of the
+// archive, such as padding added to a downloaded file. Unless the StrictArchiveEnd
+// option is used, these are ignored. It returns 0 until Next has returned io.EOF,
+// or if the archive has no end block. The data may have to be read to find its
+// size if the archive isn't seekable.
+func (r *Reader) TrailingDataSize() (int64, error) {
+	return r.pr.v.trailingSize()
+}
+
 // ReadCloser is a Reader that allows closing of the rar archive.
 type ReadCloser struct {
 	Reader
+	name string   // archive name
+	opts []Option // options used to open the archive
 }
 
 // Close closes the rar file.
 func (rc *ReadCloser) Close() error {
-	return rc.pr.Close()
+	err := rc.closeVolume()
+	if rc.dr != nil {
+		rc.dr.release()
+	}
+	return err
+}
+
+// closeVolume closes the volume rc is reading, but keeps the decodeReader and
+// its window, so they can be reused to open another file.
+func (rc *ReadCloser) closeVolume() error {
+	rc.stopAhead()
+	err := rc.pr.Close()
+	rc.pr.v.putBuffer()
+	return err
+}
+
+// Reset closes the current archive and makes rc read the archive specified
+// by name, using the options rc was opened with. Allocations are reused as
+// described for Reader.Reset. If Reset returns an error, rc must not be used
+// until Reset succeeds, though Close may still be called.
+func (rc *ReadCloser) Reset(name string) error {
+	rc.stopAhead()
+	if err := rc.pr.v.reopen(name); err != nil {
+		return err
+	}
+	rc.name = name
+	return rc.Reader.reset()
 }
 
 // OpenReader opens a RAR archive specified by the name and returns a ReadCloser.
+// If name is a volume after the first volume of a multi-volume archive, reading
+// starts at that volume. Files continued from earlier volumes are skipped, and
+// solid files return ErrSolidSkipped as their decode state is unavailable.
 func OpenReader(name string, opts ...Option) (*ReadCloser, error) {
 	pr, err := openPackedFileReader(name, opts)
 	if err != nil {
 		return nil, err
 	}
-	return &ReadCloser{Reader{pr: pr}}, nil
+	return &ReadCloser{Reader: Reader{pr: pr}, name: name, opts: opts}, nil
+}
+
+// SolidGroups returns all the files in the archive, grouped by the decode state
+// they share. Each group begins with a file that can be decoded on its own,
+// followed by the solid files that require all the previous files in the group
+// to be decoded first. Files in a non-solid archive are each in their own group.
+// Files not kept by the DuplicatePolicy option are left out of their groups,
+// though Next still has to decode them to reach the files after them.
+// The archive is read again from the start, the state of rc is not changed.
+func (rc *ReadCloser) SolidGroups() ([][]*File, error) {
+	groups, dropped, err := rc.solidGroups()
+	if err != nil || dropped == nil {
+		return groups, err
+	}
+	n := 0
+	for _, g := range groups {
+		k := 0
+		for _, f := range g {
+			if !dropped[f] {
+				g[k] = f
+				k++
+			}
+		}
+		if k > 0 {
+			groups[n] = g[:k]
+			n++
+		}
+	}
+	return groups[:n], nil
+}
+
+// solidGroups returns every file in the archive grouped as for SolidGroups,
+// and the files not kept by the DuplicatePolicy option, or nil if all are.
+// Groups are found from all the files, as a dropped file can start a group.
+func (rc *ReadCloser) solidGroups() ([][]*File, map[*File]bool, error) {
+	var o option
+	for _, f := range rc.opts {
+		f(&o)
+	}
+	fl, err := List(rc.name, append(rc.opts[:len(rc.opts):len(rc.opts)], DuplicatePolicy(DuplicateKeepAll))...)
+	if err != nil {
+		return nil, nil, err
+	}
+	keep, err := keepFiles(o.dup, len(fl), func(i int) *FileHeader { return &fl[i].FileHeader })
+	if err != nil {
+		return nil, nil, err
+	}
+	var dropped map[*File]bool
+	if keep != nil {
+		dropped = make(map[*File]bool)
+		for i, f := range fl {
+			if !keep[i] {
+				dropped[f] = true
+			}
+		}
+	}
+	var groups [][]*File
+	for _, f := range fl {
+		if f.SolidChainIndex == 0 || len(groups) == 0 {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], f)
+	}
+	return groups, dropped, nil
 }
 
 // File represents a file in a RAR archive
 type File struct {
 	FileHeader
-	pr *packedFileReader
+	pr  *packedFileReader
+	src *indexSource // block header location if read from an index, nil otherwise
 }
 
 // Open returns an io.ReadCloser that provides access to the File's contents.
+// The returned value also implements io.Seeker.
 // Open is not supported on Solid File's as their contents depend on the decoding
 // of the preceding files in the archive. Use OpenReader and Next to access Solid file
 // contents instead.
@@ -502,29 +1216,281 @@ func (f *File) Open() (io.ReadCloser, error) {
 	if f.Solid {
 		return nil, ErrSolidOpen
 	}
+	rc, err := f.open(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &fileReader{rc: rc, f: f}, nil
+}
+
+// open returns a ReadCloser for the File's contents that will decode using dr.
+// If dr is nil a new decodeReader will be allocated when needed.
+func (f *File) open(dr *decodeReader) (*ReadCloser, error) {
+	pr, err := f.packed()
+	if err != nil {
+		return nil, err
+	}
 	r := new(ReadCloser)
-	r.pr = f.pr.clone()
+	r.dr = dr
+	r.pr = pr.clone()
+	// seeking needs to know how much of the file has been read
+	r.pr.v.opt.ahead = 0
 	return r, r.pr.init()
 }
 
+// PackedInfo describes how the data of a File is stored in the archive.
+type PackedInfo struct {
+	DecoderVersion int    // RAR version of the decoder required (20, 29, 50 or 70), 0 if stored uncompressed
+	DictionarySize int    // size of the decode dictionary required
+	ChecksumType   int    // algorithm of Checksum, as for FileHeader
+	Checksum       []byte // checksum of the unpacked data as stored in the archive (nil if none)
+}
+
+// OpenRaw returns an io.ReadCloser that reads the File's data as it is stored in
+// the archive, without decrypting or decompressing it, and the information needed
+// to decode it. For files spanning multiple volumes the data blocks from each volume
+// are read in turn. Checksum is a little endian CRC32, or a BLAKE2sp hash for
+// RAR 5 files that store one. If the File is encrypted with a RAR 5 password that
+// includes a hash key, Checksum is a MAC of the checksum rather than the checksum
+// itself.
+func (f *File) OpenRaw() (io.ReadCloser, *PackedInfo, error) {
+	fpr, err := f.packed()
+	if err != nil {
+		return nil, nil, err
+	}
+	h := fpr.h
+	pi := &PackedInfo{DictionarySize: h.winSize}
+	switch h.decVer {
+	case decode20Ver:
+		pi.DecoderVersion = 20
+	case decode29Ver:
+		pi.DecoderVersion = 29
+	case decode50Ver:
+		pi.DecoderVersion = 50
+	case decode70Ver:
+		pi.DecoderVersion = 70
+	}
+	if f.ChecksumType != ChecksumNone {
+		pi.ChecksumType = f.ChecksumType
+		pi.Checksum = append([]byte(nil), f.Checksum...)
+	}
+	pr := fpr.clone()
+	if err := pr.init(); err != nil {
+		return nil, nil, err
+	}
+	return pr, pi, nil
+}
+
+// fileReader provides seekable access to the contents of a File.
+// Seek only records the new offset, which the next read moves to. Seeking
+// forward in a stored file skips over the archived data, after which the
+// file checksum is no longer checked. Otherwise the file is decoded up to the
+// new offset, starting again from the beginning of the file when seeking backwards.
+type fileReader struct {
+	rc  *ReadCloser
+	f   *File
+	off int64 // current offset in the unpacked file
+	pos int64 // offset set by Seek, moved to before the next read
+}
+
+func (fr *fileReader) Read(p []byte) (int, error) {
+	if err := fr.seek(); err != nil {
+		return 0, err
+	}
+	n, err := fr.rc.Read(p)
+	fr.off += int64(n)
+	fr.pos = fr.off
+	return n, err
+}
+
+func (fr *fileReader) WriteTo(w io.Writer) (int64, error) {
+	if err := fr.seek(); err != nil {
+		return 0, err
+	}
+	n, err := fr.rc.WriteTo(w)
+	fr.off += n
+	fr.pos = fr.off
+	return n, err
+}
+
+func (fr *fileReader) Close() error { return fr.rc.Close() }
+
+func (fr *fileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += fr.pos
+	case io.SeekEnd:
+		if fr.f.UnKnownSize {
+			return fr.pos, ErrInvalidSeek
+		}
+		offset += fr.f.UnPackedSize
+	default:
+		return fr.pos, ErrInvalidSeek
+	}
+	if offset < 0 {
+		return fr.pos, ErrInvalidSeek
+	}
+	fr.pos = offset
+	return offset, nil
+}
+
+// seek moves the file to the offset set by Seek.
+func (fr *fileReader) seek() error {
+	if fr.pos == fr.off {
+		return nil
+	}
+	if fr.pos < fr.off {
+		// reopen file and decode from the start
+		_ = fr.rc.closeVolume()
+		rc, err := fr.f.open(fr.rc.dr)
+		if err != nil {
+			return err
+		}
+		fr.rc = rc
+		fr.off = 0
+	}
+	target := fr.pos
+	if !fr.f.UnKnownSize {
+		target = min(target, fr.f.UnPackedSize)
+	}
+	n, err := fr.discard(target - fr.off)
+	if err != nil && err != io.EOF {
+		fr.off += n
+		return err
+	}
+	fr.off = fr.pos
+	return nil
+}
+
+// discard skips over the next n bytes of the file, returning the number of
+// bytes skipped.
+func (fr *fileReader) discard(n int64) (int64, error) {
+	if n <= 0 {
+		return 0, nil
+	}
+	r := &fr.rc.Reader
+	h := r.pr.h
+	if h.decVer > 0 || h.genKeys != nil || h.hasNoData() {
+		return io.CopyN(io.Discard, r, n)
+	}
+	if n <= r.pr.read-fr.off {
+		// the data has already been read from the packed file by the
+		// checksum reader, so read it from there
+		return io.CopyN(io.Discard, r, n)
+	}
+	// stored file, skip over the packed data that hasn't been read
+	if err := r.pr.skip(fr.off + n - r.pr.read); err != nil {
+		return 0, err
+	}
+	r.r = r.pr
+	if !h.UnKnownSize {
+		r.r = &limitedReader{r.r, h.UnPackedSize - fr.off - n, ErrShortFile}
+	}
+	return n, nil
+}
+
 // List returns a list of File's in the RAR archive specified by name.
+// If a RAR 5 archive contains a quick open record, the block headers cached
+// in it are used instead of reading them from throughout the archive.
+// If headers can't be read because they are encrypted and no password or
+// the wrong one was given, such as in a later volume or archive using header
+// encryption, the files read before them are returned along with an error
+// matching ErrArchiveEncrypted or ErrBadPassword.
 func List(name string, opts ...Option) ([]*File, error) {
-	r, err := OpenReader(name, opts...)
+	pr, err := openPackedFileReader(name, opts)
 	if err != nil {
 		return nil, err
 	}
-	pr := r.pr
 	defer pr.Close()
+	return listFiles(pr)
+}
+
+// OpenReaderAt returns a list of File's in the RAR archive read from ra, which
+// contains size bytes. It allows random access to archives that are not stored
+// as files, such as those held in memory. The returned File's read from ra when
+// opened, so ra must remain valid while they are in use.
+// OpenReaderAt only supports single volume archives. Files before headers
+// that can't be decrypted are returned with an error, as described for List.
+func OpenReaderAt(ra io.ReaderAt, size int64, opts ...Option) ([]*File, error) {
+	pr, err := newPackedFileReaderAt(ra, size, opts)
+	if err != nil {
+		return nil, err
+	}
+	return listFiles(pr)
+}
 
+// ListHeaders returns the headers of the files in the RAR archive read from r.
+// The data of each file is skipped without being decrypted or decoded, so it
+// can be used for a quick inventory of archives that are being streamed.
+// Extended attributes stored in service blocks are not read, so ExtendedAttrs
+// will be nil. Like NewReader, only single volume archives are supported
+// unless the VolumeProvider option is used.
+// Headers before ones that can't be decrypted are returned with an error, as
+// described for List.
+func ListHeaders(r io.Reader, opts ...Option) ([]FileHeader, error) {
+	opts = append(opts[:len(opts):len(opts)], func(o *option) { o.hdrOnly = true })
+	pr, err := newPackedFileReader(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	var fl []FileHeader
+	var prev *fileBlockHeader
+	for {
+		h, err := pr.next()
+		if prev != nil {
+			// service blocks for the previous file have now been read
+			fl[len(fl)-1] = prev.FileHeader
+		}
+		prev = h
+		if err != nil {
+			if err != io.EOF && !headersEncrypted(err) {
+				return nil, pr.v.wrapErr(err, "")
+			}
+			keep, kerr := keepFiles(pr.v.opt.dup, len(fl), func(i int) *FileHeader { return &fl[i] })
+			if kerr != nil {
+				return nil, kerr
+			}
+			if err == io.EOF {
+				return removeFiles(fl, keep), nil
+			}
+			return removeFiles(fl, keep), pr.v.wrapErr(err, "")
+		}
+		fl = append(fl, h.FileHeader)
+	}
+}
+
+// headersEncrypted reports whether err is returned for block headers that
+// can't be decrypted, after which List returns the files already read.
+func headersEncrypted(err error) bool {
+	return errors.Is(err, ErrArchiveEncrypted) || errors.Is(err, ErrBadPassword)
+}
+
+// listFiles returns a list of the File's remaining in pr, and an error if the
+// list is incomplete as described for List.
+func listFiles(pr *packedFileReader) ([]*File, error) {
 	var fl []*File
+	var prev *fileBlockHeader
 	for {
 		// get next file
 		h, err := pr.next()
+		if prev != nil {
+			// service data for the previous file has now been read
+			fl[len(fl)-1].FileHeader = prev.FileHeader
+		}
+		prev = h
 		if err != nil {
+			if err != io.EOF && !headersEncrypted(err) {
+				return nil, pr.v.wrapErr(err, "")
+			}
+			keep, kerr := keepFiles(pr.v.opt.dup, len(fl), func(i int) *FileHeader { return &fl[i].FileHeader })
+			if kerr != nil {
+				return nil, kerr
+			}
 			if err == io.EOF {
-				return fl, nil
+				return removeFiles(fl, keep), nil
 			}
-			return nil, err
+			return removeFiles(fl, keep), pr.v.wrapErr(err, "")
 		}
 
 		// save information for File
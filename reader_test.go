@@ -0,0 +1,335 @@
+package rardecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"testing/fstest"
+
+	"github.com/nwaples/rardecode/v2/internal/rartest"
+)
+
+func TestFileSeek(t *testing.T) {
+	data := testData(100000, 8)
+	arc := testArchive50(0, rartest.NewFile50("stored", data).Bytes(), compressedFile50("compressed", data, false))
+	files, err := List("a.rar", FileSystem(fstest.MapFS{"a.rar": &fstest.MapFile{Data: arc}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(data))
+	// each step seeks, then reads n bytes
+	steps := []struct {
+		offset int64
+		whence int
+		n      int
+	}{
+		{1000, io.SeekStart, 100},
+		{50000, io.SeekCurrent, 1000},
+		{10, io.SeekStart, 500},       // backwards
+		{-100, io.SeekEnd, 100},       // last bytes
+		{5000, io.SeekStart, 0},       // not read, so the file isn't moved
+		{20000, io.SeekStart, 10},     // replaces the unread seek
+		{-5, io.SeekCurrent, 10},      // backwards a little
+		{size, io.SeekStart, 10},      // at end
+		{size + 10, io.SeekStart, 10}, // past end
+		{0, io.SeekStart, len(data)},
+	}
+	for _, f := range files {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sr := r.(io.Seeker)
+		for i, s := range steps {
+			off, err := sr.Seek(s.offset, s.whence)
+			if err != nil {
+				t.Fatalf("%s: step %d: %v", f.Name, i, err)
+			}
+			b := make([]byte, s.n)
+			n, err := io.ReadFull(r, b)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				t.Fatalf("%s: step %d: %v", f.Name, i, err)
+			}
+			want := data[min(off, size):min(off+int64(s.n), size)]
+			if !bytes.Equal(b[:n], want) {
+				t.Fatalf("%s: step %d: read %d bytes at %d, want %d", f.Name, i, n, off, len(want))
+			}
+			if cur, _ := sr.Seek(0, io.SeekCurrent); cur != off+int64(n) {
+				t.Fatalf("%s: step %d: at offset %d, want %d", f.Name, i, cur, off+int64(n))
+			}
+		}
+		if _, err = sr.Seek(-1, io.SeekStart); !errors.Is(err, ErrInvalidSeek) {
+			t.Errorf("%s: seek before start: got %v, want %v", f.Name, err, ErrInvalidSeek)
+		}
+		r.Close()
+	}
+}
+
+func BenchmarkChecksumReader(b *testing.B) {
+	data := testData(1<<20, 6)
+	for _, bg := range []bool{false, true} {
+		for _, size := range []int{512, 32 << 10} {
+			name := fmt.Sprintf("Read%d", size)
+			if bg {
+				name += "/background"
+			}
+			b.Run(name, func(b *testing.B) {
+				buf := make([]byte, size)
+				b.SetBytes(int64(len(data)))
+				for i := 0; i < b.N; i++ {
+					// read exactly len(data) bytes, so the checksum isn't
+					// compared at EOF
+					cr := &checksumReader{r: &chunkReader{b: data, n: minWindowSize}, hash: newLittleEndianCRC32()}
+					if bg {
+						cr.bg = newHashWorker(cr.hash, 1<<20)
+					}
+					for n := 0; n < len(data); {
+						m, err := cr.Read(buf)
+						if err != nil {
+							b.Fatal(err)
+						}
+						n += m
+					}
+					if bg {
+						cr.bg.stop()
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestBackgroundChecksum(t *testing.T) {
+	type file struct {
+		data []byte
+		bad  bool
+	}
+	files := []file{
+		{testData(30000, 1), false},
+		{testData(5000, 2), true},
+		{testData(20000, 3), false},
+		{testData(40000, 4), true},
+	}
+	var blocks [][]byte
+	for i, f := range files {
+		h := rartest.NewFile50(fmt.Sprintf("f%d", i), f.data)
+		if i%2 == 0 {
+			h.Data = compress50(f.data, 1000)
+			h.Compression = 3 << 7
+		}
+		if f.bad {
+			h.CRC ^= 1
+		}
+		blocks = append(blocks, h.Bytes())
+	}
+	arc := testArchive50(0, blocks...)
+	for _, opts := range [][]Option{
+		nil,
+		{BackgroundChecksum(1)},
+		{BackgroundChecksum(4096)},
+		{BackgroundChecksum(1 << 20)},
+		{BackgroundChecksum(4096), ReadAhead(4096)},
+	} {
+		// files not read to the end are skipped by Next
+		for _, partial := range []bool{false, true} {
+			r, err := NewReader(bytes.NewReader(arc), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, f := range files {
+				if _, err = r.Next(); err != nil {
+					t.Fatalf("file %d: %v", i, err)
+				}
+				if partial && i%2 == 1 {
+					b := make([]byte, 100)
+					if _, err = io.ReadFull(r, b); err != nil || !bytes.Equal(b, f.data[:100]) {
+						t.Fatalf("file %d: partial read: %v", i, err)
+					}
+					continue
+				}
+				b, err := io.ReadAll(r)
+				if f.bad {
+					if !errors.Is(err, ErrBadFileChecksum) {
+						t.Errorf("%d options: file %d: got %v, want %v", len(opts), i, err, ErrBadFileChecksum)
+					}
+				} else if err != nil || !bytes.Equal(b, f.data) {
+					t.Errorf("%d options: file %d: got %d bytes, %v", len(opts), i, len(b), err)
+				}
+			}
+			if _, err = r.Next(); err != io.EOF {
+				t.Fatalf("got %v, want io.EOF", err)
+			}
+		}
+	}
+}
+
+func TestSolidDiscardNoHash(t *testing.T) {
+	arc := testArchive50(0x4, compressedFile50("a", testData(5000, 1), false)) // solid archive
+	r, err := NewReader(bytes.NewReader(arc), BackgroundChecksum(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	// as set up by Next to discard the unread file
+	if err = r.initFile(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.r.(*checksumReader); ok || r.sum != nil {
+		t.Error("checksum calculated for discarded file")
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+}
+
+func TestOpenRaw(t *testing.T) {
+	data := testData(5000, 7)
+	crc := rartest.NewFile50("crc", data)
+	sum := make([]byte, 32)
+	for i := range sum {
+		sum[i] = byte(i)
+	}
+	blake := rartest.NewFile50("blake", data)
+	blake.Flags = 0 // no CRC32
+	blake.Extra = rartest.Record50(2, append([]byte{0}, sum...))
+	blake.Data = compress50(data, 1000)
+	blake.Compression = 3 << 7
+	fsys := fstest.MapFS{"a.rar": &fstest.MapFile{Data: testArchive50(0, crc.Bytes(), blake.Bytes())}}
+	files, err := List("a.rar", FileSystem(fsys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct {
+		ver     int
+		sumType int
+		sum     []byte
+		raw     []byte
+	}{
+		{0, ChecksumCRC32, binary.LittleEndian.AppendUint32(nil, crc.CRC), crc.Data},
+		{50, ChecksumBLAKE2sp, sum, blake.Data},
+	} {
+		r, pi, err := files[i].OpenRaw()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(b, want.raw) {
+			t.Errorf("%s: got %d raw bytes, %v", files[i].Name, len(b), err)
+		}
+		if pi.DecoderVersion != want.ver || pi.ChecksumType != want.sumType || !bytes.Equal(pi.Checksum, want.sum) {
+			t.Errorf("%s: got %+v", files[i].Name, pi)
+		}
+	}
+}
+
+func TestPeek(t *testing.T) {
+	data := testData(20000, 9)
+	arc := testArchive50(0, rartest.NewFile50("stored", data).Bytes(), compressedFile50("compressed", data, false))
+	tests := []struct {
+		name string
+		fn   func(r *Reader) ([]byte, error)
+	}{
+		{"read", func(r *Reader) ([]byte, error) { return io.ReadAll(r) }},
+		{"write to", func(r *Reader) ([]byte, error) {
+			var b bytes.Buffer
+			_, err := r.WriteTo(&b)
+			return b.Bytes(), err
+		}},
+	}
+	for _, test := range tests {
+		r, err := NewReader(bytes.NewReader(arc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			h, err := r.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = r.Peek(-1); !errors.Is(err, ErrNegativeCount) {
+				t.Errorf("%s: peek -1: got %v, want %v", h.Name, err, ErrNegativeCount)
+			}
+			// peeking twice returns the same bytes, as the reader isn't advanced
+			for i := 0; i < 2; i++ {
+				if b, err := r.Peek(10000); err != nil || !bytes.Equal(b, data[:10000]) {
+					t.Fatalf("%s: peek: got %d bytes, %v", h.Name, len(b), err)
+				}
+			}
+			b, err := test.fn(r)
+			if err != nil || !bytes.Equal(b, data) {
+				t.Fatalf("%s: %s after peek: got %d bytes, %v", h.Name, test.name, len(b), err)
+			}
+			if b, err = r.Peek(1); len(b) != 0 || err != io.EOF {
+				t.Errorf("%s: peek at end: got %d bytes, %v, want io.EOF", h.Name, len(b), err)
+			}
+		}
+	}
+
+	// a peek past the end returns the whole file
+	r, err := NewReader(bytes.NewReader(arc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := r.Peek(len(data) + 1); err != io.EOF || !bytes.Equal(b, data) {
+		t.Fatalf("peek past end: got %d bytes, %v, want io.EOF", len(b), err)
+	}
+	if b, err := io.ReadAll(r); err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("read after peek past end: got %d bytes, %v", len(b), err)
+	}
+}
+
+func TestWindowReuse(t *testing.T) {
+	var blocks [][]byte
+	for i := 0; i < 4; i++ {
+		blocks = append(blocks, compressedFile50(fmt.Sprintf("f%d", i), testData(10000, byte(i)), false))
+	}
+	fsys := fstest.MapFS{"a.rar": &fstest.MapFile{Data: testArchive50(0, blocks...)}}
+	m := new(Metrics)
+	opts := []Option{FileSystem(fsys), CollectMetrics(m)}
+
+	// seeking backwards reopens the file with the same window
+	files, err := List("a.rar", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := files[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err = r.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.Copy(io.Discard, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+	if n := m.WindowBytes.Load(); n != minWindowSize {
+		t.Errorf("seeking: got %d window bytes allocated, want %d", n, minWindowSize)
+	}
+
+	// each Extract worker decodes all its files with one window
+	m.WindowBytes.Store(0)
+	err = Extract("a.rar", func(h *FileHeader, r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}, append(opts, Parallel(2))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := m.WindowBytes.Load(); n > 2*minWindowSize {
+		t.Errorf("Extract: got %d window bytes allocated, want at most %d", n, 2*minWindowSize)
+	}
+}
@@ -0,0 +1,61 @@
+package rardecode
+
+import "bytes"
+
+// RecoveryRecord describes a recovery record found in a RAR 1.5 format volume,
+// stored either in a RAR 2.x protect block or a RAR 3.x "RR" service block.
+// Recovery records are only reported. They aren't used to repair damaged data,
+// which is returned as an error, or skipped with the SkipDamaged option.
+type RecoveryRecord struct {
+	Volume      int    // volume number, starting at 0
+	VolumeName  string // volume path (empty if the archive was not opened by name)
+	Offset      int64  // offset of the block header
+	Size        int64  // size of the recovery data
+	Sectors     int    // number of recovery sectors, 0 if not known
+	TotalBlocks int64  // number of 512 byte sectors protected, 0 if not known
+}
+
+// RecoveryRecords returns the recovery records of the RAR 1.5 format volumes
+// read so far.
+func (r *Reader) RecoveryRecords() []RecoveryRecord { return r.pr.v.rr }
+
+// addRecovery records a recovery record in the block at v.boff.
+func (v *volume) addRecovery(size int64, sectors int, blocks int64) {
+	r := RecoveryRecord{Volume: v.num, Offset: v.boff, Size: size, Sectors: sectors, TotalBlocks: blocks}
+	if v.file != "" {
+		r.VolumeName = v.dir + v.file
+	}
+	v.rr = append(v.rr, r)
+}
+
+// parseProtectHeader returns the number of recovery sectors and protected
+// sectors stored in the fields of a RAR 2.x protect block header following
+// the data size. Zeros are returned if they can't be read.
+func parseProtectHeader(b readBuf) (int, int64) {
+	if _, err := b.byte(); err != nil { // version
+		return 0, 0
+	}
+	sectors, err := b.uint16()
+	if err != nil {
+		return 0, 0
+	}
+	blocks, err := b.uint32()
+	if err != nil {
+		return 0, 0
+	}
+	return int(sectors), int64(blocks)
+}
+
+// parseRRData returns the number of recovery sectors stored in the header
+// data of a RAR 3.x "RR" service block, or 0 if it isn't present.
+func parseRRData(b readBuf) int {
+	if !bytes.HasPrefix(b, []byte("Protect+")) {
+		return 0
+	}
+	b = b[8:]
+	n, err := b.uint32()
+	if err != nil {
+		return 0
+	}
+	return int(n)
+}
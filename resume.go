@@ -0,0 +1,65 @@
+package rardecode
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+var ErrBadPosition = errors.New("rardecode: no file found at resume position")
+
+// Position is the location of a file in an archive, which can be saved and
+// passed to ResumeReader to continue reading the archive at that file, such
+// as after a long running scan was interrupted. It has no references to the
+// archive so it can be stored in any encoding.
+type Position struct {
+	Volume      string // file name of the volume containing the file's first block header
+	VolumeIndex int    // index of the volume
+	Offset      int64  // offset of the first block header in the volume
+	Files       int    // number of files preceding the file in the archive
+	Solid       bool   // file depends on the decode state of earlier files, so can't be read after resuming
+}
+
+// Position returns the position of the file last returned by Next, or nil if
+// there is no current file. Resuming at the position returns the file again
+// from the first call to Next.
+func (rc *ReadCloser) Position() *Position {
+	if rc.pr.h == nil {
+		return nil
+	}
+	pos := rc.pr.pos
+	return &pos
+}
+
+// ResumeReader opens the RAR archive specified by name like OpenReader, and
+// starts reading it at the file at pos, returned by Position for the same
+// archive. Only the block headers in the volume of pos that precede it are
+// read, so it doesn't depend on the files before it having been read. Solid
+// files still return ErrSolidSkipped until a file that isn't solid is reached.
+// Next returns ErrBadPosition if there is no file at pos.
+func ResumeReader(name string, pos *Position, opts ...Option) (*ReadCloser, error) {
+	start := *pos
+	pr, err := openPosition(name, &start, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ReadCloser{Reader: Reader{pr: pr}, name: name, opts: opts}, nil
+}
+
+// openPosition opens the volume of pos, in the directory of the archive named
+// name, to start reading at the file at pos.
+func openPosition(name string, pos *Position, opts []Option) (*packedFileReader, error) {
+	pr, err := openPackedFileReader(filepath.Join(filepath.Dir(name), pos.Volume), opts)
+	if err != nil {
+		return nil, err
+	}
+	pr.start = pos
+	// RAR 1.5 volumes only store their number in the end block
+	pr.v.snum = pos.VolumeIndex
+	return pr, nil
+}
+
+// skipToStart reports whether the file at the current block header needs to
+// be skipped as it precedes the file reading was resumed at.
+func (f *packedFileReader) skipToStart() bool {
+	return f.start != nil && f.count == 0 && f.v.num == f.start.VolumeIndex && f.v.boff < f.start.Offset
+}
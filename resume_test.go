@@ -0,0 +1,175 @@
+package rardecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"testing/fstest"
+
+	"github.com/nwaples/rardecode/v2/internal/rartest"
+)
+
+// volumes15 returns a file system with a RAR 1.5 archive of volumes named
+// "v.partN.rar", each containing a stored file named after its volume.
+func volumes15(n int) fstest.MapFS {
+	fsys := make(fstest.MapFS)
+	for i := 0; i < n; i++ {
+		flags := uint16(0x0011) // volume, new naming
+		if i == 0 {
+			flags |= 0x0100 // first volume
+		}
+		end := uint16(0x0008) // volume number stored
+		if i < n-1 {
+			end |= 0x0001 // not last volume
+		}
+		f := rartest.NewFile15(fmt.Sprintf("f%d", i), []byte(fmt.Sprintf("volume %d", i)))
+		b := rartest.Archive(rartest.Sig15, rartest.Main15(flags), f.Bytes(),
+			rartest.Block15(rartest.Block15End, end, binary.LittleEndian.AppendUint16(nil, uint16(i)), nil))
+		fsys[fmt.Sprintf("v.part%d.rar", i+1)] = &fstest.MapFile{Data: b}
+	}
+	return fsys
+}
+
+// volumes50 is like volumes15 for a RAR 5 archive.
+func volumes50(n int) fstest.MapFS {
+	fsys := make(fstest.MapFS)
+	for i := 0; i < n; i++ {
+		main := rartest.Main50(0x1) // volume
+		if i > 0 {
+			fields := append(rartest.Uvarint(0x3), rartest.Uvarint(uint64(i))...) // volume with number
+			main = rartest.Block50(rartest.Block50Arc, 0, fields, nil, nil)
+		}
+		var end uint64
+		if i < n-1 {
+			end = 1 // not last volume
+		}
+		f := rartest.NewFile50(fmt.Sprintf("f%d", i), []byte(fmt.Sprintf("volume %d", i)))
+		b := rartest.Archive(rartest.Sig50, main, f.Bytes(), rartest.End50(end))
+		fsys[fmt.Sprintf("v.part%d.rar", i+1)] = &fstest.MapFile{Data: b}
+	}
+	return fsys
+}
+
+func TestResumeVolumes(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{"rar15", volumes15(3)},
+		{"rar50", volumes50(3)},
+	} {
+		opts := []Option{FileSystem(test.fsys)}
+		rc, err := OpenReader("v.part1.rar", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pos []*Position
+		for {
+			if _, err = rc.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			pos = append(pos, rc.Position())
+		}
+		rc.Close()
+		if len(pos) != 3 {
+			t.Fatalf("%s: got %d files, want 3", test.name, len(pos))
+		}
+		for i, p := range pos {
+			if p.VolumeIndex != i {
+				t.Errorf("%s: file %d: got volume index %d", test.name, i, p.VolumeIndex)
+			}
+			rc, err := ResumeReader("v.part1.rar", p, opts...)
+			if err != nil {
+				t.Fatalf("%s: file %d: %v", test.name, i, err)
+			}
+			for j := i; j < len(pos); j++ {
+				h, err := rc.Next()
+				if err != nil {
+					t.Fatalf("%s: resume at file %d: file %d: %v", test.name, i, j, err)
+				}
+				if want := fmt.Sprintf("f%d", j); h.Name != want {
+					t.Fatalf("%s: resume at file %d: got %q, want %q", test.name, i, h.Name, want)
+				}
+				if b, err := io.ReadAll(rc); err != nil || string(b) != fmt.Sprintf("volume %d", j) {
+					t.Fatalf("%s: resume at file %d: file %d: got %q, %v", test.name, i, j, b, err)
+				}
+			}
+			if _, err = rc.Next(); err != io.EOF {
+				t.Fatalf("%s: resume at file %d: got %v, want io.EOF", test.name, i, err)
+			}
+			rc.Close()
+		}
+	}
+}
+
+func TestIndexVolumes(t *testing.T) {
+	fsys := volumes15(3)
+	opts := []Option{FileSystem(fsys)}
+	rc, err := OpenReader("v.part1.rar", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var ix bytes.Buffer
+	if err = rc.SaveIndex(&ix); err != nil {
+		t.Fatal(err)
+	}
+	saved := ix.Bytes()
+	files, err := OpenWithIndex("v.part1.rar", bytes.NewReader(saved), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed, err := List("v.part1.rar", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range files {
+		if got, want := *f.FileInfo().Sys().(*FileSys), *listed[i].FileInfo().Sys().(*FileSys); got != want {
+			t.Errorf("file %d: got Sys %+v, want %+v", i, got, want)
+		}
+	}
+	// open the files in reverse, so each is loaded from its own volume
+	for i := len(files) - 1; i >= 0; i-- {
+		r, err := files[i].Open()
+		if err != nil {
+			t.Fatalf("file %d: %v", i, err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || string(b) != fmt.Sprintf("volume %d", i) {
+			t.Fatalf("file %d: got %q, %v", i, b, err)
+		}
+	}
+}
+
+func TestIndexBadVolume(t *testing.T) {
+	fsys := volumes15(2)
+	opts := []Option{FileSystem(fsys)}
+	rc, err := OpenReader("v.part1.rar", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var ix bytes.Buffer
+	if err = rc.SaveIndex(&ix); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "..", "../v.part2.rar", "dir/v.part2.rar", `dir\v.part2.rar`} {
+		b, err := json.Marshal(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bad := bytes.Replace(ix.Bytes(), []byte(`"v.part2.rar"`), b, 1)
+		if bytes.Equal(bad, ix.Bytes()) {
+			t.Fatal("volume name not found in index")
+		}
+		if _, err = OpenWithIndex("v.part1.rar", bytes.NewReader(bad), opts...); err != ErrBadIndex {
+			t.Errorf("volume %q: got %v, want %v", name, err, ErrBadIndex)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package rardecode
+
+import "strings"
+
+const maxNameComponent = 255 // maximum length of a file name component in bytes
+
+// reservedNames are device names that can't be used as a file name on Windows,
+// with or without an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeName checks that the name of h is safe to use as a relative path when
+// extracting the file on any system, and returns it with "." and empty path
+// components removed. Both '/' and '\' are treated as path separators.
+// An *UnsafeNameError is returned if the name is empty, is an absolute path,
+// starts with a drive letter, contains a ".." component, a reserved Windows
+// device name such as CON or NUL, a component ending with a dot or space, a
+// character Windows doesn't allow in names, or a component longer than 255 bytes.
+func SanitizeName(h *FileHeader) (string, error) {
+	name := h.Name
+	unsafe := func(reason string) (string, error) {
+		return "", &UnsafeNameError{Name: name, Reason: reason}
+	}
+	if len(name) >= 2 && name[1] == ':' && isLetter(name[0]) {
+		return unsafe("drive letter")
+	}
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") {
+		return unsafe("absolute path")
+	}
+	var parts []string
+	for _, s := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		switch {
+		case s == ".":
+			continue
+		case s == "..":
+			return unsafe("parent directory component")
+		case len(s) > maxNameComponent:
+			return unsafe("component too long")
+		case strings.ContainsAny(s, "<>:\"|?*") || strings.IndexFunc(s, func(r rune) bool { return r < 0x20 }) >= 0:
+			return unsafe("invalid character")
+		case strings.HasSuffix(s, ".") || strings.HasSuffix(s, " "):
+			return unsafe("component ends with a dot or space")
+		}
+		base, _, _ := strings.Cut(s, ".")
+		if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+			return unsafe("reserved device name")
+		}
+		parts = append(parts, s)
+	}
+	if len(parts) == 0 {
+		return unsafe("empty name")
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+func isLetter(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+
+// RejectUnsafeNames makes reading an archive return an *UnsafeNameError for a
+// file whose name isn't accepted by SanitizeName, instead of returning the file.
+func RejectUnsafeNames(reject bool) Option {
+	return func(o *option) { o.safeNm = reject }
+}
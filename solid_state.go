@@ -0,0 +1,95 @@
+package rardecode
+
+import (
+	"errors"
+	"slices"
+)
+
+var ErrSolidState = errors.New("rardecode: solid decode state unavailable")
+
+// SolidState is a snapshot of the decoder state needed to read a file in a
+// solid archive, so that later files can be read again without decoding all
+// the files before them. It holds a copy of the decode window, which is as
+// large as the archive's dictionary.
+type SolidState struct {
+	pos Position      // position of the file the state is for
+	dr  *decodeReader // decoder state before the file, nil if none is needed
+}
+
+// Position returns the position of the file s can be used to read.
+func (s *SolidState) Position() Position { return s.pos }
+
+// SolidState returns a snapshot of the decoder state for the file last
+// returned by Next, which must be called before any of the file is read.
+// Passing it to ResumeSolid reads the archive again from the file, with the
+// file and any solid files following it readable. ErrSolidState is returned
+// if the file has been read, or the archive uses a RAR 1.5 to 4.x compression
+// format which doesn't support snapshots. A file that can't be read because a
+// previous file was skipped returns ErrSolidSkipped.
+func (rc *ReadCloser) SolidState() (*SolidState, error) {
+	h := rc.pr.h
+	if h == nil || rc.r != nil {
+		return nil, ErrSolidState
+	}
+	s := &SolidState{pos: rc.pr.pos}
+	if h.decVer == 0 || !h.Solid || rc.dr == nil {
+		return s, nil
+	}
+	if rc.skipped {
+		return nil, ErrSolidSkipped
+	}
+	if s.dr = rc.dr.clone(); s.dr == nil {
+		return nil, ErrSolidState
+	}
+	return s, nil
+}
+
+// ResumeSolid opens the RAR archive specified by name like ResumeReader,
+// starting at the file s was taken for, and restores the decoder state of s
+// so that the file and the solid files following it can be read. s may be
+// used to resume reading any number of times.
+func ResumeSolid(name string, s *SolidState, opts ...Option) (*ReadCloser, error) {
+	rc, err := ResumeReader(name, &s.pos, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if s.dr != nil {
+		rc.dr = s.dr.clone()
+	}
+	rc.resumed = true
+	return rc, nil
+}
+
+// cloner is implemented by decoders whose state can be copied.
+type cloner interface {
+	clone() decoder
+}
+
+// clone returns a copy of the decoding state of d between files that shares no
+// memory with d, or nil if the decoder can't be copied.
+func (d *decodeReader) clone() *decodeReader {
+	c := &decodeReader{size: d.size, r: d.r, w: d.w}
+	if d.dec != nil {
+		dc, ok := d.dec.(cloner)
+		if !ok {
+			return nil
+		}
+		c.dec = dc.clone()
+	}
+	c.win = slices.Clone(d.win)
+	for _, f := range d.fl {
+		fc := *f
+		c.fl = append(c.fl, &fc)
+	}
+	return c
+}
+
+func (d *decoder50) clone() decoder {
+	c := *d
+	c.br = rar5BitReader{}
+	c.codeLength = c.buf[:len(d.codeLength)]
+	for _, h := range []*huffmanDecoder{&c.mainDecoder, &c.offsetDecoder, &c.lowoffsetDecoder, &c.lengthDecoder} {
+		h.symbol = slices.Clone(h.symbol)
+	}
+	return &c
+}
@@ -0,0 +1,143 @@
+package rardecode
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrNoPasswordCheck = errors.New("rardecode: archive has no password check value")
+
+// VerifyResult is the result of verifying the contents of a file in an archive.
+type VerifyResult struct {
+	Header *FileHeader // header of the file verified
+	Err    error       // nil if the file decoded without error and its checksum matched
+}
+
+// verify decodes and discards the contents of r, returning any error.
+func verify(r io.Reader) error {
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+// Verify decodes and discards the contents of each remaining file in the archive,
+// returning the result for each file. Errors decoding a file's contents are
+// reported in its VerifyResult. An error reading the archive headers stops
+// verification and is returned along with the results so far.
+func (r *Reader) Verify() ([]VerifyResult, error) {
+	var res []VerifyResult
+	for {
+		h, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return res, err
+		}
+		res = append(res, VerifyResult{Header: h, Err: verify(r)})
+	}
+}
+
+// VolumeStatus is the result of checking a volume of an archive.
+type VolumeStatus struct {
+	Num  int    // volume number, starting at 0
+	Name string // volume path, empty if the volume couldn't be opened
+	Err  error  // first error found in the volume, nil if no problems were found
+}
+
+// CheckVolumes reads the block headers of each volume in the archive specified
+// by name, without decoding any file data, and returns the status of each volume
+// read. It checks block header crc's, volume numbering, that files continue correctly
+// across volumes, and that the archive end is reached without any missing volumes.
+// Checking stops at the first error found, which is reported in the status of the
+// volume where it occurred. An error is only returned if the archive can't be opened.
+func CheckVolumes(name string, opts ...Option) ([]VolumeStatus, error) {
+	pr, err := openPackedFileReader(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer pr.Close()
+
+	res := []VolumeStatus{{Name: name}}
+	for {
+		// read one block at a time so each volume change is seen
+		err = pr.nextBlock()
+		if err == io.EOF {
+			_, err = pr.next()
+		}
+		v := pr.v
+		if v.num != res[len(res)-1].Num {
+			vs := VolumeStatus{Num: v.num}
+			if v.f != nil {
+				vs.Name = v.dir + v.file
+			}
+			res = append(res, vs)
+		}
+		if err != nil {
+			if err != io.EOF {
+				res[len(res)-1].Err = v.wrapErr(err, "")
+			}
+			return res, nil
+		}
+	}
+}
+
+// Verify decodes and discards the File's contents, returning an error if the
+// contents couldn't be decoded or the checksum didn't match.
+func (f *File) Verify() error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	err = verify(r)
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CheckPassword reports whether pass is the correct password for the archive
+// specified by name, without decoding any file data. The password is checked
+// by decrypting the first block header of archives with encrypted headers, or
+// against the password check value stored with each encrypted RAR 5 file.
+// It returns true if the archive isn't encrypted. ErrNoPasswordCheck is returned
+// if the archive has encrypted files that can only be checked by decoding them.
+func CheckPassword(name, pass string, opts ...Option) (bool, error) {
+	opts = append(opts[:len(opts):len(opts)], Password(pass), func(o *option) {
+		o.passFn = nil
+		o.passes = nil
+		o.hdrOnly = true
+	})
+	pr, err := openPackedFileReader(name, opts)
+	if err != nil {
+		return false, err
+	}
+	defer pr.Close()
+
+	unchecked := false // an encrypted file was found without a password check value
+	for {
+		h, err := pr.next()
+		switch {
+		case err == io.EOF:
+			if unchecked {
+				return false, ErrNoPasswordCheck
+			}
+			return true, nil
+		case err == ErrBadPassword:
+			return false, nil
+		case err != nil:
+			return false, pr.v.wrapErr(err, "")
+		case h.HeaderEncrypted:
+			// the header was decrypted and its crc matched
+			return true, nil
+		case h.genKeys == nil:
+		case !h.pwCheck:
+			unchecked = true
+		default:
+			err = h.genKeys()
+			if err == ErrBadPassword {
+				return false, nil
+			}
+			return err == nil, err
+		}
+	}
+}
@@ -35,13 +35,18 @@ func (v *vm) setIP(ip uint32) {
 	v.ipMod = true
 }
 
-// execute runs a list of commands on the vm.
-func (v *vm) execute(cmd []command) {
+// execute runs a list of commands on the vm, returning the number of commands
+// run. It returns false if the program was stopped because it would have run
+// more than max commands.
+func (v *vm) execute(cmd []command, max int) (int, bool) {
 	v.ip = 0 // reset instruction pointer
-	for n := 0; n < maxCommands; n++ {
+	for n := 0; ; n++ {
 		ip := v.ip
 		if ip >= uint32(len(cmd)) {
-			return
+			return n, true
+		}
+		if n >= max {
+			return n, false
 		}
 		ins := cmd[ip]
 		ins.f(v, ins.bm, ins.op) // run cpu instruction
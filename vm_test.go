@@ -0,0 +1,58 @@
+package rardecode
+
+import (
+	"errors"
+	"testing"
+)
+
+// nopProgram returns a vm program that runs n commands before ending.
+func nopProgram(n int) []command {
+	cmds := make([]command, n)
+	for i := range cmds {
+		cmds[i] = command{f: func(v *vm, bm bool, op []operand) {}}
+	}
+	return cmds
+}
+
+func TestVMInstructionLimit(t *testing.T) {
+	const size = 100
+	for _, test := range []struct {
+		limit int
+		err   error
+	}{
+		{size - 1, ErrVMLimitExceeded},
+		{size, nil},
+		{size + 1, nil},
+	} {
+		m := new(Metrics)
+		f := &vmFilter{code: nopProgram(size), lim: decodeLimits{vmCmds: test.limit}, stats: m}
+		_, err := f.execute(nil, nil, make([]byte, 16), 0)
+		if !errors.Is(err, test.err) {
+			t.Errorf("limit %d: got error %v, want %v", test.limit, err, test.err)
+		}
+		if want := int64(min(test.limit, size)); m.VMInstructions.Load() != want {
+			t.Errorf("limit %d: got %d instructions, want %d", test.limit, m.VMInstructions.Load(), want)
+		}
+	}
+}
+
+func TestVMOutputLimit(t *testing.T) {
+	const size = 16 // output is the whole buffer, as the program doesn't change it
+	for _, test := range []struct {
+		limit int
+		err   error
+	}{
+		{size - 1, ErrVMLimitExceeded},
+		{size, nil},
+		{size + 1, nil},
+	} {
+		m := new(Metrics)
+		f := &vmFilter{code: nopProgram(1), lim: decodeLimits{vmOut: test.limit}, stats: m}
+		b, err := f.execute(nil, nil, make([]byte, size), 0)
+		if !errors.Is(err, test.err) {
+			t.Errorf("limit %d: got error %v, want %v", test.limit, err, test.err)
+		} else if err == nil && (len(b) != size || m.VMOutputBytes.Load() != size) {
+			t.Errorf("limit %d: got %d bytes, %d counted, want %d", test.limit, len(b), m.VMOutputBytes.Load(), size)
+		}
+	}
+}
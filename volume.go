@@ -8,28 +8,71 @@ import (
 	"io"
 	"io/fs"
 	"math"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 )
 
 const (
-	maxSfxSize = 0x100000 // maximum number of bytes to read when searching for RAR signature
+	maxSfxSize = 0x400000 // default maximum number of bytes to read when searching for RAR signature
 	sigPrefix  = "Rar!\x1A\x07"
 )
 
+const (
+	minAdaptiveBuf = 4096 // default smallest size of an AdaptiveBuffer read buffer
+	adaptSteps     = 4    // reads of the same kind in a row before an AdaptiveBuffer is resized
+)
+
 var (
 	ErrNoSig            = errors.New("rardecode: RAR signature not found")
 	ErrVerMismatch      = errors.New("rardecode: volume version mistmatch")
+	ErrBadVolumeNumber  = errors.New("rardecode: volume number out of sequence")
+	ErrWrongVolumeSet   = errors.New("rardecode: volume belongs to a different archive")
+	ErrTrailingData     = errors.New("rardecode: data found after archive end")
 	ErrArchiveNameEmpty = errors.New("rardecode: archive name empty")
 	ErrFileNameRequired = errors.New("rardecode: filename required for multi volume archive")
 )
 
 type option struct {
-	bsize int     // size to be use for bufio.Reader
-	fs    fs.FS   // filesystem to use to open files
-	pass  *string // password for encrypted volumes
+	bsize    int          // size to be use for bufio.Reader
+	bmax     int          // maximum size of an AdaptiveBuffer bufio.Reader (0 to disable)
+	fs       fs.FS        // filesystem to use to open files
+	pass     *string      // password for encrypted volumes
+	passes   []string     // passwords to try in order
+	passFn   passwordFunc // function to request passwords
+	parallel int          // maximum number of files to decode concurrently
+	maxDict  int64        // maximum decode dictionary size (0 for no limit)
+	maxSfx   int64        // maximum number of bytes to search for the RAR signature
+	maxFiles int          // maximum number of files in an archive (0 for no limit)
+	maxHdr   int          // maximum size of a block header (0 for no limit)
+	maxPPM   int          // maximum ppm model memory (0 for no limit)
+	maxVMCmd int          // maximum vm instructions per filter (0 for default)
+	maxVMOut int          // maximum vm filter output size (0 for no limit)
+	noVM     bool         // disable non-standard vm filters
+	skipDmg  bool         // search for the next valid block header after a corrupt one
+	hdrOnly  bool         // only read headers, service block data is skipped
+	nameDec  nameDecoder  // decodes RAR 1.5 file names that aren't unicode
+	normNm   nameFunc     // normalizes file names
+	namer    VolumeNamer  // provides volume file names
+	findVol  bool         // search the archive directory for volumes that can't be found by name
+	win      []byte       // caller provided decode window
+	noSum    bool         // don't verify file checksums
+	strict   bool         // return ErrTrailingData if data follows the archive end block
+	mmap     bool         // map volume files into memory
+	dup      int          // DuplicatePolicy for files with the same name
+	safeNm   bool         // return an UnsafeNameError for names rejected by SanitizeName
+	check    bool         // return errors for anomalies that are tolerated by default
+	lenient  bool         // skip non-critical service blocks with bad crcs
+	ahead    int          // number of bytes to decode ahead of reads in a separate goroutine
+	bgSum    int          // number of bytes queued to be hashed in a separate goroutine
+	dec      Decrypter    // creates decrypters, nil to use crypto/aes
+	winDir   *string      // directory for disk windows of dictionaries larger than maxDict
+
+	volFn func(volnum int) (io.Reader, error) // provides volumes for streamed archives
+	blkFn BlockFunc                           // called for each block header read
+	fltFn FilterFunc                          // called for each filter applied to decoded data
+	volCh VolumeFunc                          // called when reading moves to the next volume
+	stats *Metrics                            // counts resources used by readers
 }
 
 // An Option is used for optional archive extraction settings.
@@ -40,7 +83,29 @@ func BufferSize(size int) Option {
 	return func(o *option) { o.bsize = size }
 }
 
+// AdaptiveBuffer makes the read buffer change size between BufferSize, or 4KB
+// if it isn't set, and max bytes depending on how the archive is read. The
+// buffer doubles in size while file data is read sequentially, and shrinks
+// back once data is repeatedly skipped by seeking, such as when only headers
+// are read, so that reading each header doesn't fill a large buffer. Buffers
+// are kept in a pool when a ReadCloser is closed, so readers returned by
+// File.Open reuse the buffers of closed ones.
+func AdaptiveBuffer(max int) Option {
+	return func(o *option) { o.bmax = max }
+}
+
 // FileSystem sets the fs.FS to be used for opening archive volumes.
+// Volumes held in memory, such as files passed to the package compiled to
+// WebAssembly in a browser, can be read with an in-memory fs.FS:
+//
+//	fsys := fstest.MapFS{
+//		"archive.part1.rar": {Data: part1},
+//		"archive.part2.rar": {Data: part2},
+//	}
+//	rc, err := rardecode.OpenReader("archive.part1.rar", rardecode.FileSystem(fsys))
+//
+// With a FileSystem set, the os package is only used for the temporary files
+// of the DiskWindow option.
 func FileSystem(fs fs.FS) Option {
 	return func(o *option) { o.fs = fs }
 }
@@ -50,18 +115,316 @@ func Password(pass string) Option {
 	return func(o *option) { o.pass = &pass }
 }
 
+// Passwords sets a list of passwords to try in order when a file, or the archive
+// headers, are encrypted. A password is only known to be incorrect if a password
+// check value is stored with the encrypted data, which is the case for most
+// RAR 5 archives. Otherwise the first password in the list is used. The
+// password found to be correct is kept for later files, with the list only
+// tried again if it is incorrect for one of them, and its index in the list is
+// recorded in FileHeader.PasswordIndex. If no password in the list is correct,
+// the password function set by PasswordFunc, if any, is called.
+func Passwords(passes []string) Option {
+	return func(o *option) { o.passes = passes }
+}
+
+// PasswordFunc sets a function to be called to request a password when a file,
+// or the archive headers, are encrypted and no password has been set.
+// For RAR 5 archives it is also called when the current password is found to be
+// incorrect. fh is the header of the encrypted file, or nil if the archive headers
+// are encrypted. attempt is the number of previous calls for the same request.
+// Returning an error stops the request and the error is returned to the caller.
+func PasswordFunc(fn func(fh *FileHeader, attempt int) (string, error)) Option {
+	return func(o *option) { o.passFn = fn }
+}
+
+// Parallel sets the maximum number of files that Extract will decode concurrently.
+// Only files in non-solid archives can be decoded concurrently.
+func Parallel(n int) Option {
+	return func(o *option) { o.parallel = n }
+}
+
+// MaxDictionarySize sets the maximum decode dictionary size that will be
+// allocated when decoding a file. Files requiring a larger dictionary will
+// return ErrDictionaryTooLarge. RAR 7 archives may use dictionaries up to 64GB.
+func MaxDictionarySize(size int64) Option {
+	return func(o *option) { o.maxDict = size }
+}
+
+// MaxSFXSize sets the maximum number of bytes that will be searched for the RAR
+// signature at the start of each volume. Self-extracting archives begin with an
+// executable stub that can be larger than the default limit of 4MB.
+func MaxSFXSize(size int) Option {
+	return func(o *option) { o.maxSfx = int64(size) }
+}
+
+// MaxFiles sets the maximum number of files that will be read from an archive.
+// Reading past the limit returns ErrLimitsExceeded.
+func MaxFiles(n int) Option {
+	return func(o *option) { o.maxFiles = n }
+}
+
+// MaxHeaderSize sets the maximum size in bytes of a block header that will be read.
+// Larger headers return ErrLimitsExceeded. RAR 5 headers are never read if
+// they are larger than 2MB, and RAR 1.5 headers are limited to 64KB by the format.
+func MaxHeaderSize(size int) Option {
+	return func(o *option) { o.maxHdr = size }
+}
+
+// MaxPPMMemory sets the maximum number of bytes of memory that will be allocated
+// for a PPM decode model. Files using PPM compression that request a larger
+// model will return ErrPPMMemoryTooLarge. RAR 2.9 models may use up to 256MB.
+func MaxPPMMemory(size int) Option {
+	return func(o *option) { o.maxPPM = size }
+}
+
+// MaxVMInstructions sets the maximum number of instructions a RAR 2.9 VM filter
+// may run for each block it filters. Filters that would run more return
+// ErrVMLimitExceeded. By default filters are stopped silently after 25 million
+// instructions.
+func MaxVMInstructions(n int) Option {
+	return func(o *option) { o.maxVMCmd = n }
+}
+
+// MaxVMOutputSize sets the maximum size in bytes of the output of a RAR 2.9 VM
+// filter. Larger output returns ErrVMLimitExceeded.
+func MaxVMOutputSize(size int) Option {
+	return func(o *option) { o.maxVMOut = size }
+}
+
+// Mmap makes volume files opened by name be mapped into memory rather than read
+// with system calls. Mapped data is read directly rather than copied through a
+// read buffer, so BufferSize and AdaptiveBuffer don't apply to it. Mmap has no
+// effect when the FileSystem option is used, and files that can't be mapped
+// are read normally. Mapped files must not be truncated while they are open.
+func Mmap(use bool) Option {
+	return func(o *option) { o.mmap = use }
+}
+
+// StrictArchiveEnd makes reading an archive return ErrTrailingData if any data
+// follows the end block of the last volume. By default such data, such as the
+// padding added to downloaded files, is ignored.
+func StrictArchiveEnd(strict bool) Option {
+	return func(o *option) { o.strict = strict }
+}
+
+// Strict makes reading an archive return errors for anomalies that are
+// tolerated by default, for validating archives. It includes StrictArchiveEnd,
+// and also rejects archives without an end block, stored files whose
+// packed and unpacked sizes differ, unknown RAR 5 file header records and
+// file times stored as zero. Strict(false) doesn't undo StrictArchiveEnd.
+func Strict(strict bool) Option {
+	return func(o *option) {
+		if strict {
+			o.strict = true
+		}
+		o.check = strict
+	}
+}
+
+// Permissive makes reading an archive skip service blocks with a bad header crc,
+// and service data with a bad checksum, instead of returning an error. These
+// blocks only hold optional data such as comments, extended attributes or
+// NTFS streams. Skipped blocks are reported by Reader.DamagedRegions.
+func Permissive(permissive bool) Option {
+	return func(o *option) { o.lenient = permissive }
+}
+
+// SkipChecksums disables verifying the checksum of each file's contents as it
+// is read, which is faster when the contents don't need to be checked. Corrupt
+// data may then be returned without an error. Extended attributes stored in
+// service blocks are still checked.
+func SkipChecksums(skip bool) Option {
+	return func(o *option) { o.noSum = skip }
+}
+
+// UseExternalWindow makes the decoder use buf as its dictionary window instead
+// of allocating one, so the caller controls the memory used. Files that need a
+// larger dictionary than len(buf) return ErrDictionaryTooLarge. The window must
+// be at least 256KB, the smallest dictionary size. As buf is shared by all
+// readers using the option, only one file may be decoded at a time, and the
+// Parallel option is ignored.
+func UseExternalWindow(buf []byte) Option {
+	return func(o *option) { o.win = buf }
+}
+
+// ReportFilters sets a function to be called each time a filter is applied to
+// decoded file data, identifying the filter and the range of the file it
+// processed. Archivers use filters to make executables and multimedia data
+// compress better, so they show how an archive was produced.
+func ReportFilters(fn FilterFunc) Option {
+	return func(o *option) { o.fltFn = fn }
+}
+
+// DisableVMFilters prevents the RAR 2.9 VM from running filter programs embedded
+// in archives. The standard filters used by the RAR archiver are replaced with
+// native code and still work. Files using any other filter return ErrVMFilterDisabled.
+func DisableVMFilters() Option {
+	return func(o *option) { o.noVM = true }
+}
+
+// SkipDamaged sets whether reading continues past corrupt block headers.
+// When enabled, a block header with a bad crc or invalid contents is skipped
+// by searching forward for the next valid block header, and a file header with
+// invalid contents is skipped along with its data. The skipped data is reported
+// by Reader.DamagedRegions. Encrypted headers can't be skipped, and a header
+// is only found by the search if it fits in half of the BufferSize buffer.
+func SkipDamaged(skip bool) Option {
+	return func(o *option) { o.skipDmg = skip }
+}
+
+// NameDecoder sets the function used to convert file names in RAR 1.5 format
+// archives that are not stored as unicode. Old archivers stored these names in
+// the OEM or ANSI codepage of the system creating the archive, so they will be
+// incorrect for non-ASCII names unless converted. By default the name is used
+// as is. If fn returns an error the unconverted name is used instead.
+// A decoder from golang.org/x/text/encoding can be used, eg.
+//
+//	rardecode.NameDecoder(func(b []byte) (string, error) {
+//		b, err := charmap.CodePage866.NewDecoder().Bytes(b)
+//		return string(b), err
+//	})
+func NameDecoder(fn func(name []byte) (string, error)) Option {
+	return func(o *option) { o.nameDec = fn }
+}
+
+// NormalizeNames sets a function that is applied to the name of each file as it
+// is read, before any other processing of the name such as RejectUnsafeNames.
+// Archives created on macOS may store names in Unicode normalization form NFD,
+// so they can be converted to the NFC form expected by most other systems
+// using the golang.org/x/text/unicode/norm package, eg.
+//
+//	rardecode.NormalizeNames(norm.NFC.String)
+func NormalizeNames(fn func(name string) string) Option {
+	return func(o *option) { o.normNm = fn }
+}
+
+// A VolumeNamer provides the file names of the volumes of a multi-volume archive.
+type VolumeNamer interface {
+	// NextVolumeName returns the file name of volume number volnum, where the
+	// first volume is 0. name is the file name of the previous volume.
+	// Names are relative to the directory of the first volume.
+	NextVolumeName(name string, volnum int) string
+}
+
+// VolumeNaming sets the VolumeNamer used to find the volumes following the first
+// volume of an archive opened by name. By default the names are generated from
+// the first volume name using either the name.partN.rar or the name.rNN naming
+// scheme of the RAR archiver.
+func VolumeNaming(n VolumeNamer) Option {
+	return func(o *option) { o.namer = n }
+}
+
+// FindVolumes sets whether the directory of an archive opened by name is searched
+// for volumes that aren't found using the volume naming scheme. When a volume name
+// doesn't exist, the block headers of each file in the directory are read to
+// find the file with the required volume number. This allows reading volume sets
+// that have been renamed. If more than one file has the same volume number, the
+// one whose name best matches the previous volume is used.
+func FindVolumes(find bool) Option {
+	return func(o *option) { o.findVol = find }
+}
+
+// VolumeProvider sets the function used by NewReader to get the next volume of a
+// multi-volume archive. fn is called with the number of the volume required,
+// where the first volume is 0. It should return an error satisfying
+// errors.Is(err, fs.ErrNotExist) if the volume doesn't exist.
+// Readers returned by fn are not closed.
+func VolumeProvider(fn func(volnum int) (io.Reader, error)) Option {
+	return func(o *option) { o.volFn = fn }
+}
+
+// VolumeChange describes reading moving from one volume of a multi-volume
+// archive to the next.
+type VolumeChange struct {
+	OldName string // path of the volume that was finished, empty if it came from a VolumeProvider
+	NewName string // path of the volume now being read, empty if it came from a VolumeProvider
+	Volume  int    // number of the new volume, where the first volume is 0
+	Offset  int64  // offset in the old volume where reading stopped
+}
+
+// VolumeFunc is the type of function called by the VolumeChanged option.
+type VolumeFunc func(c *VolumeChange)
+
+// VolumeChanged sets a function that is called each time reading moves on to
+// the next volume, after the new volume has been opened. The old volume has
+// been closed, so a pipeline that downloads and extracts volumes can delete
+// it, unless Files returned by List still refer to it. fn may be called
+// concurrently when Extract decodes files in parallel.
+func VolumeChanged(fn VolumeFunc) Option {
+	return func(o *option) { o.volCh = fn }
+}
+
+// archiveFlags are the flags read from the main archive header of a volume.
+type archiveFlags struct {
+	solid    bool // archive is solid
+	locked   bool // archive can't be modified
+	recovery bool // volume has a recovery record
+	volume   bool // volume is part of a multi-volume archive
+	first    bool // first volume of a multi-volume archive
+}
+
+// bufReader is the buffered reader a volume reads its current file with.
+// It is a *bufio.Reader, or a *memReader for a file mapped into memory.
+type bufReader interface {
+	io.Reader
+	Buffered() int
+	Discard(n int) (int, error)
+	Peek(n int) ([]byte, error)
+	ReadSlice(delim byte) ([]byte, error)
+	Reset(r io.Reader)
+	Size() int
+}
+
 // volume extends a fileBlockReader to be used across multiple
 // files in a multi-volume archive
 type volume struct {
 	f    io.Reader     // current file handle
-	br   *bufio.Reader // buffered reader for current volume file
+	br   bufReader     // buffered reader for current volume file
+	buf  *bufio.Reader // read buffer kept across volumes, used as br unless f is mapped
+	ra   io.ReaderAt   // random access volume data, used instead of opening files
+	size int64         // size of ra
 	dir  string        // current volume directory path
 	file string        // current volume file name
 	num  int           // volume number
 	old  bool          // uses old naming scheme
 	off  int64         // current file offset
+	pipe bool          // f is an io.Seeker that failed to seek, so data is skipped by reading it
+	seq  int           // AdaptiveBuffer reads in a row, > 0 for sequential data and < 0 for seeks
 	ver  int           // archive file format version
+	sfx  int64         // size of data preceding the signature in the first volume
+	boff int64         // offset of the current block header
+	blk  string        // type of the current block, empty if its header hasn't been read
+	mid  bool          // reading began at a volume after the first volume of the archive
+	snum int           // number of the first volume read if mid, when known before reading it
+	end  bool          // the end block of the last volume has been read
+	trl  int64         // size of the data following the end block, -1 if not yet measured
+	arc  archiveFlags  // flags from the main archive header of the current volume
 	opt  option        // optional settings
+
+	dmg  []DamagedRegion  // data skipped by the SkipDamaged option
+	rr   []RecoveryRecord // recovery records found in RAR 1.5 volumes
+	vols map[int][]string // volume file names by volume number, found by the FindVolumes option
+
+	async bool     // a ReadAhead goroutine is reading, so later queues changes
+	queue []func() // changes queued by later
+}
+
+// later calls fn, which makes a change visible to the caller such as updating
+// the current file's header. While a ReadAhead goroutine is reading, fn is
+// queued instead, to be called on the caller's goroutine with the data read
+// after it.
+func (v *volume) later(fn func()) {
+	if v.async {
+		v.queue = append(v.queue, fn)
+		return
+	}
+	fn()
+}
+
+// setBlock records the offset and type of the block being read, for use in errors.
+func (v *volume) setBlock(off int64, blk string) {
+	v.boff = off
+	v.blk = blk
 }
 
 func (v *volume) setOpts(opts []Option) {
@@ -71,29 +434,84 @@ func (v *volume) setOpts(opts []Option) {
 }
 
 func (v *volume) setBuffer() {
-	if v.br != nil {
-		v.br.Reset(v.f)
+	v.pipe = false
+	if _, ok := v.f.(*mappedFile); ok {
+		// read the mapped data directly instead of copying it to a buffer
+		mr := new(memReader)
+		mr.Reset(v.f)
+		v.br = mr
+		return
+	}
+	if v.buf != nil {
+		v.buf.Reset(v.f)
+	} else if v.opt.bmax > 0 {
+		v.buf = getReader(v.f, v.minBuffer())
 	} else if size := v.opt.bsize; size > 0 {
-		v.br = bufio.NewReaderSize(v.f, size)
+		v.buf = bufio.NewReaderSize(v.f, size)
 	} else if br, ok := v.f.(*bufio.Reader); ok {
-		v.br = br
+		v.buf = br
 	} else {
-		v.br = bufio.NewReader(v.f)
+		v.buf = bufio.NewReader(v.f)
 	}
+	v.br = v.buf
 }
 
-func (v *volume) openFile(file string) error {
-	var err error
-	var f io.Reader
+// minBuffer returns the smallest size of an AdaptiveBuffer read buffer.
+func (v *volume) minBuffer() int {
+	if v.opt.bsize > 0 {
+		return v.opt.bsize
+	}
+	return minAdaptiveBuf
+}
 
-	if len(file) == 0 {
-		return ErrArchiveNameEmpty
+// adapt records a read of file data that refilled the whole buffer if seq is
+// true, or a seek past data that wasn't buffered. An AdaptiveBuffer is resized
+// after adaptSteps of the same kind in a row. The buffer must be empty.
+func (v *volume) adapt(seq bool) {
+	if v.opt.bmax <= 0 || v.br != bufReader(v.buf) {
+		return
 	}
-	if fs := v.opt.fs; fs != nil {
-		f, err = fs.Open(v.dir + file)
+	if seq {
+		v.seq = max(v.seq, 0) + 1
 	} else {
-		f, err = os.Open(v.dir + file)
+		v.seq = min(v.seq, 0) - 1
+	}
+	size := v.br.Size()
+	switch {
+	case v.seq >= adaptSteps && size < v.opt.bmax:
+		size = min(size*2, v.opt.bmax)
+	case v.seq <= -adaptSteps && size > v.minBuffer():
+		size = v.minBuffer()
+	default:
+		return
 	}
+	v.seq = 0
+	putReader(v.buf)
+	v.buf = getReader(v.f, size)
+	v.br = v.buf
+}
+
+// putBuffer returns an AdaptiveBuffer read buffer to its pool.
+func (v *volume) putBuffer() {
+	if v.opt.bmax > 0 && v.buf != nil {
+		putReader(v.buf)
+		v.buf, v.br = nil, nil
+	}
+}
+
+// fsys returns the file system volumes are opened from.
+func (v *volume) fsys() fs.FS {
+	if v.opt.fs != nil {
+		return v.opt.fs
+	}
+	return osFS{mmap: v.opt.mmap}
+}
+
+func (v *volume) openFile(file string) error {
+	if len(file) == 0 {
+		return ErrArchiveNameEmpty
+	}
+	f, err := v.fsys().Open(v.dir + file)
 	if err != nil {
 		return err
 	}
@@ -104,6 +522,11 @@ func (v *volume) openFile(file string) error {
 }
 
 func (v *volume) init() error {
+	if v.ra != nil && v.num == 0 {
+		v.f = io.NewSectionReader(v.ra, 0, v.size)
+		v.setBuffer()
+		return v.discard(v.off)
+	}
 	err := v.openFile(v.file)
 	if err != nil {
 		return err
@@ -120,6 +543,7 @@ func (v *volume) clone() *volume {
 	*nv = *v
 	nv.f = nil
 	nv.br = nil
+	nv.buf = nil
 	return nv
 }
 
@@ -142,10 +566,17 @@ func (v *volume) discard(n int64) error {
 	l := int64(v.br.Buffered())
 	if n <= l {
 		_, err = v.br.Discard(int(n))
-	} else if sr, ok := v.f.(io.Seeker); ok {
-		n -= l
-		_, err = sr.Seek(n, io.SeekCurrent)
-		v.br.Reset(v.f)
+	} else if sr, ok := v.f.(io.Seeker); ok && !v.pipe {
+		// seek past the data instead of reading it
+		if _, err = sr.Seek(n-l, io.SeekCurrent); err == nil {
+			v.br.Reset(v.f)
+			v.adapt(false)
+			return nil
+		}
+		// an *os.File may be a pipe or terminal that can't seek
+		v.pipe = true
+		v.off -= n
+		return v.discard(n)
 	} else {
 		for n > math.MaxInt && err == nil {
 			_, err = v.br.Discard(math.MaxInt)
@@ -161,6 +592,155 @@ func (v *volume) discard(n int64) error {
 	return err
 }
 
+// archiveEnd is called when the end block of the last volume has been read.
+// It returns io.EOF, or ErrTrailingData if the StrictArchiveEnd option is used
+// and the volume has data after the end block.
+func (v *volume) archiveEnd() error {
+	v.end = true
+	v.trl = -1
+	if !v.opt.strict {
+		return io.EOF
+	}
+	n, err := v.trailingSize()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrTrailingData
+	}
+	return io.EOF
+}
+
+// nextArchive searches the data following the end block of the archive for the
+// signature of another archive, and prepares v to read it. It returns io.EOF if
+// there is none, or if the data has already been consumed by trailingSize.
+func (v *volume) nextArchive() error {
+	if !v.end || v.trl >= 0 {
+		return io.EOF
+	}
+	off := v.off
+	v.num, v.snum, v.end, v.mid, v.arc = 0, 0, false, false, archiveFlags{}
+	err := v.findSig()
+	v.off += off
+	if err == ErrNoSig || err == io.EOF {
+		// the data following the end block has been read
+		v.end, v.trl = true, v.off-off
+		return io.EOF
+	}
+	return err
+}
+
+// trailingSize returns the size of the data following the end block of the
+// archive. It seeks to the end of the volume if possible, otherwise the rest
+// of the volume is read.
+func (v *volume) trailingSize() (int64, error) {
+	if !v.end || v.f == nil {
+		return 0, nil
+	}
+	if v.trl >= 0 {
+		return v.trl, nil
+	}
+	if sr, ok := v.f.(io.Seeker); ok && !v.pipe {
+		if cur, err := sr.Seek(0, io.SeekCurrent); err == nil {
+			end, err := sr.Seek(0, io.SeekEnd)
+			if err != nil {
+				return 0, err
+			}
+			v.trl = end - cur + int64(v.br.Buffered())
+			v.br.Reset(v.f)
+			return v.trl, nil
+		}
+	}
+	n, err := io.Copy(io.Discard, v.br)
+	if err != nil {
+		return 0, err
+	}
+	v.trl = n
+	return n, nil
+}
+
+// remaining returns the size of the data left to read in the current volume
+// file, or -1 if it can't be found without reading it.
+func (v *volume) remaining() int64 {
+	sr, ok := v.f.(io.Seeker)
+	if !ok || v.pipe {
+		return -1
+	}
+	cur, err := sr.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	end, err := sr.Seek(0, io.SeekEnd)
+	if _, serr := sr.Seek(cur, io.SeekStart); err != nil || serr != nil {
+		return -1
+	}
+	return end - cur + int64(v.br.Buffered())
+}
+
+// seekable returns true if the volume supports seek.
+func (v *volume) seekable() bool {
+	_, ok := v.f.(io.Seeker)
+	return ok && !v.pipe
+}
+
+// seek sets the offset for the next read from the volume to off.
+// It should only be used if v.seekable returns true.
+func (v *volume) seek(off int64) error {
+	sr := v.f.(io.Seeker)
+	_, err := sr.Seek(off-v.off-int64(v.br.Buffered()), io.SeekCurrent)
+	v.br.Reset(v.f)
+	v.off = off
+	return err
+}
+
+// skipDamaged skips the data following the block header at v.boff, which
+// is corrupt, up to the next offset that valid reports contains a block header.
+// err is the error returned reading the corrupt header. skipDamaged returns
+// false if the SkipDamaged option isn't set or err can't be skipped.
+func (v *volume) skipDamaged(err error, valid func(r sliceReader) bool) bool {
+	if !v.opt.skipDmg || (err != ErrBadHeaderCRC && err != ErrCorruptBlockHeader && !varintErr(err)) {
+		return false
+	}
+	start := v.boff
+	if v.seekable() {
+		if v.seek(start+1) != nil {
+			return false
+		}
+	}
+	for {
+		b, perr := v.br.Peek(v.br.Size())
+		if len(b) == 0 {
+			break
+		}
+		n := len(b)
+		if perr == nil {
+			// leave room for a header that extends past the end of b
+			n /= 2
+		}
+		i := 0
+		for ; i < n; i++ {
+			r := bufSliceReader(b[i:])
+			if valid(&r) {
+				break
+			}
+		}
+		if v.discard(int64(i)) != nil || i < n {
+			break
+		}
+	}
+	v.addDamage(start, err)
+	return true
+}
+
+// addDamage records the data from start to the current offset as skipped because of err.
+func (v *volume) addDamage(start int64, err error) {
+	r := DamagedRegion{Volume: v.num, Offset: start, Size: v.off - start, Err: err}
+	if v.file != "" {
+		r.VolumeName = v.dir + v.file
+	}
+	v.dmg = append(v.dmg, r)
+}
+
 func (v *volume) peek(n int) ([]byte, error) {
 	b, err := v.br.Peek(n)
 	if err == io.EOF && len(b) > 0 {
@@ -198,10 +778,14 @@ func (v *volume) Read(p []byte) (int, error) {
 }
 
 // findSig searches for the RAR signature and version at the beginning of a file.
-// It searches no more than maxSfxSize bytes.
+// It searches no more than the MaxSFXSize option bytes, or maxSfxSize if not set.
 func (v *volume) findSig() error {
+	limit := v.opt.maxSfx
+	if limit <= 0 {
+		limit = maxSfxSize
+	}
 	v.off = 0
-	for v.off <= maxSfxSize {
+	for v.off <= limit {
 		b, err := v.br.ReadSlice(sigPrefix[0])
 		v.off += int64(len(b))
 		if err == bufio.ErrBufferFull {
@@ -233,6 +817,7 @@ func (v *volume) findSig() error {
 		v.off += int64(len(b))
 		if v.num == 0 {
 			v.ver = ver
+			v.sfx = v.off - sigLen(ver)
 		} else if v.ver != ver {
 			return ErrVerMismatch
 		}
@@ -241,6 +826,54 @@ func (v *volume) findSig() error {
 	return ErrNoSig
 }
 
+// Archive format versions.
+const (
+	FormatRAR15 = 0 // RAR 1.5 to 4.x archive format
+	FormatRAR50 = 1 // RAR 5.0 and later archive format, also used by RAR 7
+)
+
+// Format describes the format and location of a RAR archive.
+type Format struct {
+	Version int   // archive format version, FormatRAR15 or FormatRAR50
+	Offset  int64 // offset of the RAR signature from the start of the input
+}
+
+// IsSFX reports whether the archive is preceded by other data,
+// such as the executable stub of a self-extracting archive.
+func (f Format) IsSFX() bool { return f.Offset > 0 }
+
+// sigLen returns the length of the signature for archive version ver.
+func sigLen(ver int) int64 {
+	if ver == FormatRAR15 {
+		return int64(len(sigPrefix) + 1)
+	}
+	return int64(len(sigPrefix) + 2)
+}
+
+// DetectFormat reads from r until it finds a RAR signature and returns the
+// archive format version and the offset of the signature. No more than the
+// default MaxSFXSize limit is searched. ErrNoSig is returned if no signature
+// is found, and ErrUnknownVersion if the format version is not supported.
+func DetectFormat(r io.Reader) (Format, error) {
+	v := &volume{f: r}
+	v.setBuffer()
+	err := v.findSig()
+	if err != nil {
+		return Format{}, err
+	}
+	f := Format{Version: v.ver, Offset: v.sfx}
+	if f.Version != FormatRAR15 && f.Version != FormatRAR50 {
+		return f, ErrUnknownVersion
+	}
+	return f, nil
+}
+
+// IsRAR reports whether b begins with the signature of a supported RAR archive format.
+func IsRAR(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(sigPrefix+"\x00")) ||
+		bytes.HasPrefix(b, []byte(sigPrefix+"\x01\x00"))
+}
+
 func nextNewVolName(file string) string {
 	var inDigit bool
 	var m []int
@@ -258,6 +891,10 @@ func nextNewVolName(file string) string {
 	if inDigit {
 		m = append(m, len(file))
 	}
+	if len(m) == 0 {
+		// no volume number in the name, so use the old naming scheme
+		return nextOldVolName(file)
+	}
 	if l := len(m); l >= 4 {
 		// More than 1 match so assume name.part###of###.rar style.
 		// Take the last 2 matches where the first is the volume number.
@@ -322,6 +959,9 @@ func hasDigits(s string) bool {
 
 // openNextFile opens the next volume file in the archive.
 func (v *volume) openNextFile() error {
+	if n := v.opt.namer; n != nil {
+		return v.openFile(n.NextVolumeName(v.file, v.num+1))
+	}
 	file := v.file
 	if v.num == 0 {
 		// check file extensions
@@ -341,10 +981,10 @@ func (v *volume) openNextFile() error {
 			if hasDigits(file) {
 				// found digits, try using new naming scheme
 				err := v.openFile(nextNewVolName(file))
-				if err != nil && os.IsNotExist(err) {
+				if errors.Is(err, fs.ErrNotExist) {
 					// file didn't exist, try old naming scheme
 					oldErr := v.openFile(nextOldVolName(file))
-					if oldErr == nil || !os.IsNotExist(err) {
+					if oldErr == nil || !errors.Is(oldErr, fs.ErrNotExist) {
 						v.old = true
 						return oldErr
 					}
@@ -363,9 +1003,119 @@ func (v *volume) openNextFile() error {
 	return v.openFile(file)
 }
 
+// findVolume returns the name of the file in the archive's directory containing
+// volume number num. The volume numbers of all the files in the directory are read
+// when first called.
+func (v *volume) findVolume(num int) (string, error) {
+	if v.vols == nil {
+		var ents []fs.DirEntry
+		var err error
+		dir := strings.TrimSuffix(v.dir, "/")
+		if dir == "" {
+			dir = "."
+		}
+		if ents, err = fs.ReadDir(v.fsys(), dir); err != nil {
+			return "", err
+		}
+		v.vols = make(map[int][]string)
+		for _, e := range ents {
+			if e.Type().IsRegular() {
+				if n := v.volumeNumber(e.Name()); n >= 0 {
+					v.vols[n] = append(v.vols[n], e.Name())
+				}
+			}
+		}
+	}
+	var file string
+	best := -1
+	for _, name := range v.vols[num] {
+		if n := commonPrefixLen(name, v.file); n > best {
+			file, best = name, n
+		}
+	}
+	if file == "" {
+		return "", fs.ErrNotExist
+	}
+	return file, nil
+}
+
+// commonPrefixLen returns the length of the common prefix of a and b, ignoring case.
+func commonPrefixLen(a, b string) int {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// volumeNumber returns the volume number of the archive volume in the file
+// with the given name in v's directory, or -1 if it isn't a volume from an
+// archive of the same format.
+func (v *volume) volumeNumber(file string) int {
+	nv := &volume{dir: v.dir, opt: v.opt}
+	if nv.openFile(file) != nil {
+		return -1
+	}
+	defer nv.Close()
+	if nv.findSig() != nil || nv.ver != v.ver {
+		return -1
+	}
+	var n int
+	var err error
+	switch v.ver {
+	case FormatRAR15:
+		n, err = newArchive15(v.opt.pass, nil).volumeNumber(nv)
+	case FormatRAR50:
+		n, err = newArchive50(v.opt.pass, nil).volumeNumber(nv)
+	default:
+		return -1
+	}
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// nextReader gets the next volume from the VolumeProvider option.
+func (v *volume) nextReader() error {
+	r, err := v.opt.volFn(v.num + 1)
+	v.num++
+	if err != nil {
+		return err
+	}
+	v.f = r
+	v.setBuffer()
+	return v.findSig()
+}
+
+// reportVolume calls the VolumeFunc set by the VolumeChanged option, if any,
+// after the volume following the one named old has been opened. off is the
+// offset reading stopped at in the old volume.
+func (v *volume) reportVolume(old string, off int64) {
+	if v.opt.volCh == nil {
+		return
+	}
+	c := &VolumeChange{Volume: v.num, Offset: off}
+	if old != "" {
+		c.OldName = filepath.Join(v.dir, old)
+		c.NewName = filepath.Join(v.dir, v.file)
+	}
+	fn := v.opt.volCh
+	v.later(func() { fn(c) })
+}
+
 func (v *volume) next() error {
+	old, off := v.file, v.off
 	if len(v.file) == 0 {
-		return ErrFileNameRequired
+		if v.opt.volFn == nil {
+			return ErrFileNameRequired
+		}
+		err := v.nextReader()
+		if err == nil {
+			v.reportVolume(old, off)
+		}
+		return err
 	}
 	err := v.Close()
 	if err != nil {
@@ -373,6 +1123,12 @@ func (v *volume) next() error {
 	}
 	v.f = nil
 	err = v.openNextFile() // Open next volume file
+	if v.opt.findVol && errors.Is(err, fs.ErrNotExist) {
+		var file string
+		if file, err = v.findVolume(v.num + 1); err == nil {
+			err = v.openFile(file)
+		}
+	}
 	v.num++
 	if err != nil {
 		return err
@@ -380,8 +1136,10 @@ func (v *volume) next() error {
 	err = v.findSig()
 	if err != nil {
 		_ = v.Close()
+		return err
 	}
-	return err
+	v.reportVolume(old, off)
+	return nil
 }
 
 func newVolume(r io.Reader, opts []Option) (*volume, error) {
@@ -391,6 +1149,46 @@ func newVolume(r io.Reader, opts []Option) (*volume, error) {
 	return v, v.findSig()
 }
 
+func newVolumeAt(ra io.ReaderAt, size int64, opts []Option) (*volume, error) {
+	v, err := newVolume(io.NewSectionReader(ra, 0, size), opts)
+	if err != nil {
+		return nil, err
+	}
+	v.ra = ra
+	v.size = size
+	return v, nil
+}
+
+// reset reinitializes v to read a new archive from r. The options and any
+// read buffer allocated by v are kept.
+func (v *volume) reset(r io.Reader) error {
+	buf := v.buf
+	if v.f == io.Reader(buf) {
+		buf = nil // buffer belongs to the caller
+	}
+	_ = v.Close()
+	*v = volume{f: r, buf: buf, opt: v.opt}
+	v.setBuffer()
+	return v.findSig()
+}
+
+// reopen reinitializes v to read the archive specified by name, keeping the
+// options and read buffer.
+func (v *volume) reopen(name string) error {
+	_ = v.Close()
+	*v = volume{buf: v.buf, opt: v.opt}
+	v.dir, v.file = filepath.Split(name)
+	err := v.openFile(v.file)
+	if err != nil {
+		return err
+	}
+	err = v.findSig()
+	if err != nil {
+		_ = v.Close()
+	}
+	return err
+}
+
 func openVolume(name string, opts []Option) (*volume, error) {
 	v := &volume{}
 	v.dir, v.file = filepath.Split(name)